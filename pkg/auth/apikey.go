@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"github.com/igevin/sepweb/pkg/context"
+)
+
+// APIKeyLookup 根据 API key 找到调用方，不存在的时候返回 ErrInvalidCredentials
+type APIKeyLookup func(ctx *context.Context, key string) (*Principal, error)
+
+// APIKey 从请求头 header 或者查询参数 query 里读取 API key，header 优先。
+// 为空字符串的那个不会被读取。放在查询参数里的 key 容易出现在日志里，尽量使用请求头
+func APIKey(header, query string, lookup APIKeyLookup) Authenticator {
+	return &apiKey{header: header, query: query, lookup: lookup}
+}
+
+// APIKeys 用固定的 API key 认证。保存的是 key 的哈希，查找的耗时和 key 的内容无关
+func APIKeys(header, query string, keys map[string]*Principal) Authenticator {
+	hashed := make(map[[32]byte]*Principal, len(keys))
+	for k, p := range keys {
+		hashed[sha256.Sum256([]byte(k))] = p
+	}
+	return APIKey(header, query, func(ctx *context.Context, key string) (*Principal, error) {
+		p, ok := hashed[sha256.Sum256([]byte(key))]
+		if !ok {
+			return nil, ErrInvalidCredentials
+		}
+		return p, nil
+	})
+}
+
+type apiKey struct {
+	header string
+	query  string
+	lookup APIKeyLookup
+}
+
+func (a *apiKey) Authenticate(ctx *context.Context) (*Principal, error) {
+	var key string
+	if a.header != "" {
+		key = ctx.Req.Header.Get(a.header)
+	}
+	if key == "" && a.query != "" {
+		key, _ = ctx.QueryValue(a.query).ToString()
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	return a.lookup(ctx, key)
+}
+
+// Challenge API key 没有标准的认证方案，这里使用常见的写法
+func (a *apiKey) Challenge(err error) string {
+	if a.header == "" {
+		return `ApiKey query=` + quote(a.query)
+	}
+	return `ApiKey header=` + quote(a.header)
+}
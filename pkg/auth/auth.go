@@ -0,0 +1,111 @@
+package auth
+
+import (
+	stdctx "context"
+	"errors"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"net/http"
+)
+
+// ErrNoCredentials 表示请求里没有这种认证方式需要的凭证，会继续尝试下一个 Authenticator
+var ErrNoCredentials = errors.New("auth: 请求里没有凭证")
+
+// ErrInvalidCredentials 表示凭证不正确
+var ErrInvalidCredentials = errors.New("auth: 凭证不正确")
+
+// Principal 是认证通过的调用方
+type Principal struct {
+	// Subject 是调用方的唯一标识，比如用户 ID
+	Subject string
+	Roles   []string
+	// Attributes 是其它信息，比如 JWT 里的全部声明
+	Attributes map[string]any
+}
+
+// Authenticator 是一种认证方式
+type Authenticator interface {
+	// Authenticate 请求里没有对应的凭证的时候返回 ErrNoCredentials
+	Authenticate(ctx *context.Context) (*Principal, error)
+	// Challenge 返回认证失败的时候 WWW-Authenticate 头部的值，err 是 Authenticate 返回的错误
+	Challenge(err error) string
+}
+
+var principalKey = context.NewKey[*Principal]("auth-principal")
+
+// FromContext 取出认证通过的调用方，ctx 可以是 *context.Context，也可以是请求的 context
+func FromContext(ctx stdctx.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*Principal)
+	return p, ok && p != nil
+}
+
+type MiddlewareBuilder struct {
+	authenticators []Authenticator
+	optional       bool
+}
+
+// NewMiddlewareBuilder 按照顺序尝试 authenticators，第一个拿到凭证的决定认证的结果
+func NewMiddlewareBuilder(authenticators ...Authenticator) *MiddlewareBuilder {
+	return &MiddlewareBuilder{authenticators: authenticators}
+}
+
+// Optional 让没有带任何凭证的请求也可以继续处理，只是拿不到 Principal。
+// 带了凭证但是不正确的请求依旧会被拒绝
+func (m *MiddlewareBuilder) Optional() *MiddlewareBuilder {
+	m.optional = true
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			for _, a := range m.authenticators {
+				p, err := a.Authenticate(ctx)
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				}
+				if err != nil {
+					unauthorized(ctx, err, a.Challenge(err))
+					return
+				}
+				context.Set(ctx, principalKey, p)
+				ctx.Req = ctx.Req.WithContext(stdctx.WithValue(ctx.Req.Context(), principalKey, p))
+				next(ctx)
+				return
+			}
+			if m.optional {
+				next(ctx)
+				return
+			}
+			challenges := make([]string, 0, len(m.authenticators))
+			for _, a := range m.authenticators {
+				challenges = append(challenges, a.Challenge(ErrNoCredentials))
+			}
+			unauthorized(ctx, ErrNoCredentials, challenges...)
+		}
+	}
+}
+
+func unauthorized(ctx *context.Context, err error, challenges ...string) {
+	for _, c := range challenges {
+		if c != "" {
+			ctx.Resp.Header().Add("WWW-Authenticate", c)
+		}
+	}
+	ctx.AbortWithError(http.StatusUnauthorized, err)
+	ctx.RespData = []byte(http.StatusText(http.StatusUnauthorized))
+}
+
+// quote 生成 WWW-Authenticate 里参数的值
+func quote(s string) string {
+	b := make([]byte, 0, len(s)+2)
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b = append(b, '\\')
+		}
+		b = append(b, s[i])
+	}
+	return string(append(b, '"'))
+}
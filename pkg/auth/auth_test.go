@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"fmt"
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/auth/jwt"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	keys, err := jwt.NewMemoryKeySet(jwt.Key{ID: "k1", Alg: jwt.HS256, Material: secret})
+	require.NoError(t, err)
+	token, err := jwt.Sign(jwt.Claims{
+		"sub":   "42",
+		"roles": []string{"admin"},
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}, jwt.HS256, "k1", secret)
+	require.NoError(t, err)
+	expired, err := jwt.Sign(jwt.Claims{"sub": "42", "exp": time.Now().Add(-time.Minute).Unix()}, jwt.HS256, "k1", secret)
+	require.NoError(t, err)
+
+	authenticators := []Authenticator{
+		Bearer("api", jwt.NewVerifier(keys), nil),
+		BasicUsers("api", map[string]string{"tom": "secret"}),
+		APIKeys("X-API-Key", "api_key", map[string]*Principal{"key-1": {Subject: "service"}}),
+	}
+	s := sepweb.NewHttpServer()
+	handle := func(ctx *context.Context) {
+		p, ok := FromContext(ctx)
+		if !ok {
+			ctx.String(http.StatusOK, "anonymous")
+			return
+		}
+		// 请求的 context 里也能拿到
+		p2, _ := FromContext(ctx.Req.Context())
+		assert.Same(t, p, p2)
+		ctx.String(http.StatusOK, fmt.Sprintf("%s %v", p.Subject, p.Roles))
+	}
+	s.Get("/private", handle, NewMiddlewareBuilder(authenticators...).Build())
+	s.Get("/public", handle, NewMiddlewareBuilder(authenticators...).Optional().Build())
+
+	testCases := []struct {
+		name          string
+		path          string
+		header        map[string]string
+		wantCode      int
+		wantBody      string
+		wantChallenge string
+	}{
+		{name: "bearer", path: "/private", header: map[string]string{"Authorization": "Bearer " + token}, wantCode: http.StatusOK, wantBody: "42 [admin]"},
+		{
+			name:          "expired bearer",
+			path:          "/private",
+			header:        map[string]string{"Authorization": "Bearer " + expired},
+			wantCode:      http.StatusUnauthorized,
+			wantChallenge: `Bearer realm="api", error="invalid_token", error_description="The access token expired"`,
+		},
+		{name: "basic", path: "/private", header: map[string]string{"Authorization": basicAuth("tom", "secret")}, wantCode: http.StatusOK, wantBody: "tom []"},
+		{name: "wrong password", path: "/private", header: map[string]string{"Authorization": basicAuth("tom", "guess")}, wantCode: http.StatusUnauthorized, wantChallenge: `Basic realm="api", charset="UTF-8"`},
+		{name: "unknown user", path: "/private", header: map[string]string{"Authorization": basicAuth("jerry", "secret")}, wantCode: http.StatusUnauthorized},
+		{name: "api key header", path: "/private", header: map[string]string{"X-API-Key": "key-1"}, wantCode: http.StatusOK, wantBody: "service []"},
+		{name: "api key query", path: "/private?api_key=key-1", wantCode: http.StatusOK, wantBody: "service []"},
+		{name: "wrong api key", path: "/private?api_key=key-2", wantCode: http.StatusUnauthorized, wantChallenge: `ApiKey header="X-API-Key"`},
+		{
+			name:     "no credentials",
+			path:     "/private",
+			wantCode: http.StatusUnauthorized,
+			wantChallenge: strings.Join([]string{`Bearer realm="api"`, `Basic realm="api", charset="UTF-8"`,
+				`ApiKey header="X-API-Key"`}, "; "),
+		},
+		{name: "optional", path: "/public", wantCode: http.StatusOK, wantBody: "anonymous"},
+		{name: "optional with bad credentials", path: "/public", header: map[string]string{"X-API-Key": "key-2"}, wantCode: http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			if tc.wantBody != "" {
+				assert.Equal(t, tc.wantBody, recorder.Body.String())
+			}
+			if tc.wantChallenge != "" {
+				assert.Equal(t, tc.wantChallenge, strings.Join(recorder.Header().Values("WWW-Authenticate"), "; "))
+			}
+		})
+	}
+}
+
+func basicAuth(username, password string) string {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth(username, password)
+	return req.Header.Get("Authorization")
+}
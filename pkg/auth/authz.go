@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"errors"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"net/http"
+)
+
+// ErrForbidden 表示调用方已经认证，但是没有访问的权限
+var ErrForbidden = errors.New("auth: 没有访问权限")
+
+// Evaluator 判断调用方有没有角色或者权限
+type Evaluator interface {
+	HasRole(p *Principal, role string) bool
+	// HasPermission 的 ctx 是当前请求，用来支持依赖路径参数之类的条件
+	HasPermission(ctx *context.Context, p *Principal, perm string) bool
+}
+
+// Authorizer 生成路由级别的授权中间件，这些中间件要放在认证中间件之后
+type Authorizer struct {
+	evaluator Evaluator
+}
+
+func NewAuthorizer(evaluator Evaluator) *Authorizer {
+	return &Authorizer{evaluator: evaluator}
+}
+
+// RequireRoles 要求调用方至少有 roles 中的一个角色
+func (a *Authorizer) RequireRoles(roles ...string) middleware.Middleware {
+	return a.Require(func(ctx *context.Context, p *Principal) bool {
+		for _, role := range roles {
+			if a.evaluator.HasRole(p, role) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// RequirePermission 要求调用方有 perms 中的全部权限
+func (a *Authorizer) RequirePermission(perms ...string) middleware.Middleware {
+	return a.Require(func(ctx *context.Context, p *Principal) bool {
+		for _, perm := range perms {
+			if !a.evaluator.HasPermission(ctx, p, perm) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Require 用任意的条件授权
+func (a *Authorizer) Require(cond Condition) middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			p, ok := FromContext(ctx)
+			if !ok {
+				// 没有经过认证，或者是 Optional 模式下的匿名请求
+				unauthorized(ctx, ErrNoCredentials)
+				return
+			}
+			if !cond(ctx, p) {
+				ctx.AbortWithError(http.StatusForbidden, ErrForbidden)
+				ctx.RespData = []byte(http.StatusText(http.StatusForbidden))
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+// Condition 是基于属性的授权条件
+type Condition func(ctx *context.Context, p *Principal) bool
+
+// ParamIsSubject 要求路径参数 param 等于调用方的 Subject，一般用来判断资源的所有者
+func ParamIsSubject(param string) Condition {
+	return func(ctx *context.Context, p *Principal) bool {
+		val, ok := ctx.PathParams[param]
+		return ok && val == p.Subject
+	}
+}
+
+// ParamInAttribute 要求路径参数 param 等于调用方的属性 attr，
+// 属性可以是 string 或者 []string，比如用户所属的租户
+func ParamInAttribute(param, attr string) Condition {
+	return func(ctx *context.Context, p *Principal) bool {
+		val, ok := ctx.PathParams[param]
+		if !ok {
+			return false
+		}
+		switch v := p.Attributes[attr].(type) {
+		case string:
+			return v == val
+		case []string:
+			for _, s := range v {
+				if s == val {
+					return true
+				}
+			}
+		case []any:
+			for _, s := range v {
+				if s == val {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
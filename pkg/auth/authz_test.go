@@ -0,0 +1,100 @@
+package auth
+
+import (
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/middleware/errhdl"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newAuthzServer 每次都创建新的 RBAC，测试用例之间不会互相影响
+func newAuthzServer() *sepweb.HttpServer {
+	rbac := NewRBAC().
+		Role("admin", "editor").
+		Role("editor", "viewer").
+		// 环不会导致死循环
+		Role("viewer", "admin-alias").
+		Role("admin-alias", "viewer").
+		Grant("viewer", "order:read", ParamIsSubject("uid")).
+		Grant("editor", "order:read").
+		Grant("editor", "order:write", ParamInAttribute("tenant", "tenants")).
+		Grant("admin", "user:*")
+	authz := NewAuthorizer(rbac)
+
+	s := sepweb.NewHttpServer()
+	s.Use(errhdl.NewMiddlewareBuilder().RegisterError(http.StatusForbidden, []byte("forbidden page")).Build())
+	s.Use(NewMiddlewareBuilder(APIKeys("X-API-Key", "", map[string]*Principal{
+		"admin":  {Subject: "1", Roles: []string{"admin"}},
+		"editor": {Subject: "2", Roles: []string{"editor"}, Attributes: map[string]any{"tenants": []any{"acme"}}},
+		"viewer": {Subject: "3", Roles: []string{"viewer"}},
+	})).Optional().Build())
+	ok := func(ctx *context.Context) {
+		ctx.String(http.StatusOK, "ok")
+	}
+	s.Get("/admin", ok, authz.RequireRoles("admin"))
+	s.Get("/edit", ok, authz.RequireRoles("admin", "editor"))
+	s.Get("/users/:uid/orders", ok, authz.RequirePermission("order:read"))
+	s.Post("/tenants/:tenant/orders", ok, authz.RequirePermission("order:write"))
+	s.Post("/users", ok, authz.RequirePermission("user:create"))
+	return s
+}
+
+func TestAuthorizer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		method   string
+		path     string
+		key      string
+		wantCode int
+	}{
+		{name: "admin", path: "/admin", key: "admin", wantCode: http.StatusOK},
+		{name: "not admin", path: "/admin", key: "editor", wantCode: http.StatusForbidden},
+		{name: "anonymous", path: "/admin", wantCode: http.StatusUnauthorized},
+		{name: "any role", path: "/edit", key: "editor", wantCode: http.StatusOK},
+		{name: "inherited role", path: "/edit", key: "admin", wantCode: http.StatusOK},
+		{name: "viewer", path: "/edit", key: "viewer", wantCode: http.StatusForbidden},
+		{name: "owner", path: "/users/3/orders", key: "viewer", wantCode: http.StatusOK},
+		{name: "not owner", path: "/users/2/orders", key: "viewer", wantCode: http.StatusForbidden},
+		{name: "unconditional grant", path: "/users/3/orders", key: "editor", wantCode: http.StatusOK},
+		{name: "inherited permission", path: "/users/3/orders", key: "admin", wantCode: http.StatusOK},
+		{name: "tenant member", method: http.MethodPost, path: "/tenants/acme/orders", key: "editor", wantCode: http.StatusOK},
+		{name: "other tenant", method: http.MethodPost, path: "/tenants/evil/orders", key: "editor", wantCode: http.StatusForbidden},
+		{name: "wildcard", method: http.MethodPost, path: "/users", key: "admin", wantCode: http.StatusOK},
+		{name: "no wildcard", method: http.MethodPost, path: "/users", key: "editor", wantCode: http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tc.path, nil)
+			if tc.key != "" {
+				req.Header.Set("X-API-Key", tc.key)
+			}
+			recorder := httptest.NewRecorder()
+			newAuthzServer().ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			if tc.wantCode == http.StatusForbidden {
+				assert.Equal(t, "forbidden page", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestRBAC_ConditionGrant(t *testing.T) {
+	rbac := NewRBAC()
+	// 条件里修改授权不会死锁
+	rbac.Grant("viewer", "report:read", func(ctx *context.Context, p *Principal) bool {
+		rbac.Grant("viewer", "report:export")
+		return true
+	})
+	p := &Principal{Subject: "3", Roles: []string{"viewer"}}
+	ctx := &context.Context{}
+	assert.False(t, rbac.HasPermission(ctx, p, "report:export"))
+	assert.True(t, rbac.HasPermission(ctx, p, "report:read"))
+	assert.True(t, rbac.HasPermission(ctx, p, "report:export"))
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"github.com/igevin/sepweb/pkg/context"
+)
+
+// BasicValidator 校验用户名和密码，不正确的时候返回 ErrInvalidCredentials
+type BasicValidator func(ctx *context.Context, username, password string) (*Principal, error)
+
+// Basic 是 HTTP Basic 认证，只应该在 HTTPS 上使用
+func Basic(realm string, validate BasicValidator) Authenticator {
+	return &basic{realm: realm, validate: validate}
+}
+
+// BasicUsers 用固定的用户名和密码做 Basic 认证，比较的耗时和用户名、密码是否正确无关
+func BasicUsers(realm string, users map[string]string) Authenticator {
+	hashed := make(map[string][32]byte, len(users))
+	for user, pwd := range users {
+		hashed[user] = sha256.Sum256([]byte(pwd))
+	}
+	// 用户不存在的时候也比较一次，避免通过耗时判断用户是否存在
+	dummy := sha256.Sum256([]byte("auth: dummy password"))
+	return Basic(realm, func(ctx *context.Context, username, password string) (*Principal, error) {
+		want, ok := hashed[username]
+		if !ok {
+			want = dummy
+		}
+		got := sha256.Sum256([]byte(password))
+		if subtle.ConstantTimeCompare(want[:], got[:]) != 1 || !ok {
+			return nil, ErrInvalidCredentials
+		}
+		return &Principal{Subject: username}, nil
+	})
+}
+
+type basic struct {
+	realm    string
+	validate BasicValidator
+}
+
+func (b *basic) Authenticate(ctx *context.Context) (*Principal, error) {
+	username, password, ok := ctx.Req.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	return b.validate(ctx, username, password)
+}
+
+func (b *basic) Challenge(err error) string {
+	return "Basic realm=" + quote(b.realm) + `, charset="UTF-8"`
+}
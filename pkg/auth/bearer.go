@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"github.com/igevin/sepweb/pkg/auth/jwt"
+	"github.com/igevin/sepweb/pkg/context"
+	"strings"
+)
+
+// ClaimsMapper 把验证通过的 JWT 声明转换成 Principal
+type ClaimsMapper func(claims jwt.Claims) (*Principal, error)
+
+// DefaultClaimsMapper 使用 sub 作为 Subject，roles 作为 Roles，全部声明作为 Attributes
+func DefaultClaimsMapper(claims jwt.Claims) (*Principal, error) {
+	return &Principal{
+		Subject:    claims.Subject(),
+		Roles:      claims.Strings("roles"),
+		Attributes: claims,
+	}, nil
+}
+
+// Bearer 从 Authorization: Bearer <token> 里读取 JWT 并验证
+func Bearer(realm string, verifier *jwt.Verifier, mapper ClaimsMapper) Authenticator {
+	if mapper == nil {
+		mapper = DefaultClaimsMapper
+	}
+	return &bearer{realm: realm, verifier: verifier, mapper: mapper}
+}
+
+type bearer struct {
+	realm    string
+	verifier *jwt.Verifier
+	mapper   ClaimsMapper
+}
+
+func (b *bearer) Authenticate(ctx *context.Context) (*Principal, error) {
+	scheme, token, ok := strings.Cut(ctx.Req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+	claims, err := b.verifier.Verify(strings.TrimSpace(token))
+	if err != nil {
+		return nil, err
+	}
+	return b.mapper(claims)
+}
+
+// Challenge 按照 RFC 6750 返回，token 不正确的时候带上 invalid_token。
+// 头部里只能放 ASCII，所以不直接使用错误信息
+func (b *bearer) Challenge(err error) string {
+	c := "Bearer realm=" + quote(b.realm)
+	switch {
+	case err == nil || errors.Is(err, ErrNoCredentials):
+	case errors.Is(err, jwt.ErrExpired):
+		c += `, error="invalid_token", error_description="The access token expired"`
+	default:
+		c += `, error="invalid_token"`
+	}
+	return c
+}
@@ -0,0 +1,76 @@
+package jwt
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// maxNumericDate 是 NumericDate 的上限，超过它 float64 已经没法精确表示整数秒了
+const maxNumericDate = 1 << 53
+
+// Claims 是 JWT 的载荷，数字默认会被解析成 json.Number
+type Claims map[string]any
+
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings 读取字符串或者字符串数组，比如 aud 和 roles
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		res := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// Time 读取 NumericDate 类型的声明，比如 exp、nbf 和 iat。
+// 声明不存在，或者不是合法的 NumericDate 的时候返回 false
+func (c Claims) Time(name string) (time.Time, bool) {
+	var sec float64
+	switch v := c[name].(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		sec = f
+	case float64:
+		sec = v
+	case int64:
+		sec = float64(v)
+	case int:
+		sec = float64(v)
+	default:
+		return time.Time{}, false
+	}
+	if math.IsNaN(sec) || sec >= maxNumericDate || sec <= -maxNumericDate {
+		return time.Time{}, false
+	}
+	whole, frac := math.Modf(sec)
+	return time.Unix(int64(whole), int64(frac*float64(time.Second))), true
+}
+
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+func (c Claims) Issuer() string {
+	return c.String("iss")
+}
+
+func (c Claims) Audience() []string {
+	return c.Strings("aud")
+}
@@ -0,0 +1,238 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed      = errors.New("jwt: token 格式不正确")
+	ErrUnsupportedAlg = errors.New("jwt: 不支持的签名算法")
+	ErrKeyNotFound    = errors.New("jwt: 找不到验证签名的密钥")
+	ErrSignature      = errors.New("jwt: 签名不正确")
+	ErrExpired        = errors.New("jwt: token 已经过期")
+	ErrNotYetValid    = errors.New("jwt: token 还没有生效")
+	ErrIssuer         = errors.New("jwt: 签发者不正确")
+	ErrAudience       = errors.New("jwt: 接收者不正确")
+)
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+type VerifierOption func(v *Verifier)
+
+// Verifier 验证 token 的签名和时间、签发者、接收者等声明
+type Verifier struct {
+	keys     KeySet
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+func NewVerifier(keys KeySet, opts ...VerifierOption) *Verifier {
+	v := &Verifier{keys: keys, now: time.Now}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// WithIssuer 要求 iss 必须是 issuer
+func WithIssuer(issuer string) VerifierOption {
+	return func(v *Verifier) {
+		v.issuer = issuer
+	}
+}
+
+// WithAudience 要求 aud 里必须包含 audience
+func WithAudience(audience string) VerifierOption {
+	return func(v *Verifier) {
+		v.audience = audience
+	}
+}
+
+// WithLeeway 允许签发方和验证方的时钟有一定的误差
+func WithLeeway(leeway time.Duration) VerifierOption {
+	return func(v *Verifier) {
+		v.leeway = leeway
+	}
+}
+
+// Verify 验证 token 并返回其中的声明
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if h.Alg != HS256 && h.Alg != RS256 && h.Alg != ES256 {
+		return nil, ErrUnsupportedAlg
+	}
+	keys := v.keys.Keys(h.Kid, h.Alg)
+	if len(keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	signingInput := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range keys {
+		if verify(k, signingInput, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+
+	claims := Claims{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrMalformed
+	}
+	if err = v.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) validate(claims Claims) error {
+	now := v.now()
+	exp, ok, err := timeClaim(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if ok && !now.Before(exp.Add(v.leeway)) {
+		return ErrExpired
+	}
+	nbf, ok, err := timeClaim(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.leeway).Before(nbf) {
+		return ErrNotYetValid
+	}
+	if v.issuer != "" && claims.Issuer() != v.issuer {
+		return ErrIssuer
+	}
+	if v.audience != "" {
+		for _, aud := range claims.Audience() {
+			if aud == v.audience {
+				return nil
+			}
+		}
+		return ErrAudience
+	}
+	return nil
+}
+
+// timeClaim 读取时间声明。声明不存在的时候 ok 是 false，
+// 存在但是格式不对的时候返回 ErrMalformed，不能当成没有这个声明
+func timeClaim(claims Claims, name string) (t time.Time, ok bool, err error) {
+	if _, ok = claims[name]; !ok {
+		return time.Time{}, false, nil
+	}
+	if t, ok = claims.Time(name); !ok {
+		return time.Time{}, false, ErrMalformed
+	}
+	return t, true, nil
+}
+
+func verify(k Key, input, sig []byte) bool {
+	digest := sha256.Sum256(input)
+	switch k.Alg {
+	case HS256:
+		mac := hmac.New(sha256.New, k.Material.([]byte))
+		mac.Write(input)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case RS256:
+		return rsa.VerifyPKCS1v15(k.Material.(*rsa.PublicKey), crypto.SHA256, digest[:], sig) == nil
+	case ES256:
+		// JWS 里的 ECDSA 签名是定长的 r 和 s 直接拼起来，不是 ASN.1 格式
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k.Material.(*ecdsa.PublicKey), digest[:], r, s)
+	}
+	return false
+}
+
+// Sign 签发 token。key 对于 HS256 是 []byte，RS256 是 *rsa.PrivateKey，ES256 是 *ecdsa.PrivateKey
+func Sign(claims Claims, alg, kid string, key any) (string, error) {
+	hs, err := json.Marshal(header{Alg: alg, Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	cs, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(hs) + "." + base64.RawURLEncoding.EncodeToString(cs)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return "", ErrKeyNotFound
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case RS256:
+		pk, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return "", ErrKeyNotFound
+		}
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, pk, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	case ES256:
+		pk, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return "", ErrKeyNotFound
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, pk, digest[:])
+		if err != nil {
+			return "", err
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	default:
+		return "", ErrUnsupportedAlg
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func decodeSegment(seg string, val any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(val)
+}
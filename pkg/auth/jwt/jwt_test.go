@@ -0,0 +1,135 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifier_Verify(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keys, err := NewMemoryKeySet(
+		Key{ID: "hs", Alg: HS256, Material: secret},
+		Key{ID: "rs", Alg: RS256, Material: &rsaKey.PublicKey},
+		Key{ID: "es", Alg: ES256, Material: &ecKey.PublicKey},
+	)
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	v := NewVerifier(keys, WithIssuer("https://issuer.test"), WithAudience("api"), WithLeeway(5*time.Second))
+	v.now = func() time.Time {
+		return now
+	}
+	claims := func(extra Claims) Claims {
+		c := Claims{
+			"sub": "42",
+			"iss": "https://issuer.test",
+			"aud": []string{"web", "api"},
+			"exp": now.Add(time.Minute).Unix(),
+		}
+		for k, val := range extra {
+			c[k] = val
+		}
+		return c
+	}
+	sign := func(c Claims, alg, kid string, key any) string {
+		token, err := Sign(c, alg, kid, key)
+		require.NoError(t, err)
+		return token
+	}
+
+	testCases := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "HS256", token: sign(claims(nil), HS256, "hs", secret)},
+		{name: "RS256", token: sign(claims(nil), RS256, "rs", rsaKey)},
+		{name: "ES256", token: sign(claims(nil), ES256, "es", ecKey)},
+		{name: "without kid", token: sign(claims(nil), ES256, "", ecKey)},
+		{name: "single audience", token: sign(claims(Claims{"aud": "api"}), HS256, "hs", secret)},
+		{name: "within leeway", token: sign(claims(Claims{"exp": now.Add(-3 * time.Second).Unix()}), HS256, "hs", secret)},
+		{name: "expired", token: sign(claims(Claims{"exp": now.Add(-time.Minute).Unix()}), HS256, "hs", secret), wantErr: ErrExpired},
+		{name: "not yet valid", token: sign(claims(Claims{"nbf": now.Add(time.Minute).Unix()}), HS256, "hs", secret), wantErr: ErrNotYetValid},
+		// 格式不对的时间声明不能当成没有
+		{name: "string exp", token: sign(claims(Claims{"exp": "2020-01-01"}), HS256, "hs", secret), wantErr: ErrMalformed},
+		{name: "bool exp", token: sign(claims(Claims{"exp": true}), HS256, "hs", secret), wantErr: ErrMalformed},
+		{name: "string nbf", token: sign(claims(Claims{"nbf": "later"}), HS256, "hs", secret), wantErr: ErrMalformed},
+		{name: "exp out of range", token: sign(claims(Claims{"exp": 1e300}), HS256, "hs", secret), wantErr: ErrMalformed},
+		{name: "wrong issuer", token: sign(claims(Claims{"iss": "evil"}), HS256, "hs", secret), wantErr: ErrIssuer},
+		{name: "wrong audience", token: sign(claims(Claims{"aud": "other"}), HS256, "hs", secret), wantErr: ErrAudience},
+		{name: "unknown kid", token: sign(claims(nil), HS256, "old", secret), wantErr: ErrKeyNotFound},
+		{name: "wrong secret", token: sign(claims(nil), HS256, "hs", []byte("another secret with enough bytes!")), wantErr: ErrSignature},
+		// 用 RS256 的 kid 签 HS256，典型的算法混淆攻击
+		{name: "alg confusion", token: sign(claims(nil), HS256, "rs", secret), wantErr: ErrKeyNotFound},
+		{name: "none", token: noneToken(claims(nil)), wantErr: ErrUnsupportedAlg},
+		{name: "malformed", token: "a.b", wantErr: ErrMalformed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := v.Verify(tc.token)
+			assert.Equal(t, tc.wantErr, err)
+			if err == nil {
+				assert.Equal(t, "42", c.Subject())
+			}
+		})
+	}
+
+	// 篡改载荷之后签名不再正确
+	token := sign(claims(nil), HS256, "hs", secret)
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`))
+	_, err = v.Verify(strings.Join(parts, "."))
+	assert.Equal(t, ErrSignature, err)
+
+	// 轮换：删掉旧的密钥之后，它签发的 token 失效
+	keys.Remove("hs")
+	_, err = v.Verify(token)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestClaims_Time(t *testing.T) {
+	testCases := []struct {
+		name   string
+		val    any
+		want   time.Time
+		wantOk bool
+	}{
+		{name: "number", val: json.Number("1700000000"), want: time.Unix(1700000000, 0), wantOk: true},
+		{name: "fraction", val: 1700000000.5, want: time.Unix(1700000000, 5e8), wantOk: true},
+		{name: "int64", val: int64(1700000000), want: time.Unix(1700000000, 0), wantOk: true},
+		{name: "string", val: "1700000000"},
+		{name: "NaN", val: math.NaN()},
+		{name: "Inf", val: math.Inf(1)},
+		{name: "-Inf", val: math.Inf(-1)},
+		{name: "too large", val: json.Number("1e300")},
+		{name: "too small", val: -1e19},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := Claims{"exp": tc.val}.Time("exp")
+			assert.Equal(t, tc.wantOk, ok)
+			assert.True(t, tc.want.Equal(got))
+		})
+	}
+}
+
+func noneToken(c Claims) string {
+	token, _ := Sign(c, HS256, "", []byte("0123456789abcdef0123456789abcdef"))
+	parts := strings.Split(token, ".")
+	parts[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	return parts[0] + "." + parts[1] + "."
+}
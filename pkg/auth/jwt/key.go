@@ -0,0 +1,101 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"sync"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// Key 是验证签名用的密钥，Alg 限定了它只能用来验证哪种算法的签名，
+// 避免攻击者把 RS256 的公钥当成 HS256 的密钥使用
+type Key struct {
+	ID  string
+	Alg string
+	// Material 对于 HS256 是 []byte，RS256 是 *rsa.PublicKey，ES256 是 *ecdsa.PublicKey
+	Material any
+}
+
+func (k Key) validate() error {
+	switch k.Alg {
+	case HS256:
+		if b, ok := k.Material.([]byte); !ok || len(b) < 32 {
+			return errors.New("jwt: HS256 的密钥必须是至少 32 字节的 []byte")
+		}
+	case RS256:
+		if _, ok := k.Material.(*rsa.PublicKey); !ok {
+			return errors.New("jwt: RS256 的密钥必须是 *rsa.PublicKey")
+		}
+	case ES256:
+		if pk, ok := k.Material.(*ecdsa.PublicKey); !ok || pk.Curve != elliptic.P256() {
+			return errors.New("jwt: ES256 的密钥必须是 P-256 曲线的 *ecdsa.PublicKey")
+		}
+	default:
+		return ErrUnsupportedAlg
+	}
+	return nil
+}
+
+// KeySet 根据 JWT 头部的 kid 和 alg 找到验证签名的密钥
+type KeySet interface {
+	// Keys 返回所有可能用来验证的密钥。kid 为空的时候返回所有算法匹配的密钥
+	Keys(kid, alg string) []Key
+}
+
+// MemoryKeySet 是可以在运行时增删密钥的 KeySet。
+// 轮换密钥的时候先添加新的密钥，等旧密钥签发的 token 都过期之后再删除旧的
+type MemoryKeySet struct {
+	mu   sync.RWMutex
+	keys map[string]Key
+}
+
+func NewMemoryKeySet(keys ...Key) (*MemoryKeySet, error) {
+	s := &MemoryKeySet{keys: make(map[string]Key, len(keys))}
+	for _, k := range keys {
+		if err := s.Add(k); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *MemoryKeySet) Add(k Key) error {
+	if err := k.validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[k.ID] = k
+	return nil
+}
+
+func (s *MemoryKeySet) Remove(kid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, kid)
+}
+
+func (s *MemoryKeySet) Keys(kid, alg string) []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if kid != "" {
+		if k, ok := s.keys[kid]; ok && k.Alg == alg {
+			return []Key{k}
+		}
+		return nil
+	}
+	var res []Key
+	for _, k := range s.keys {
+		if k.Alg == alg {
+			res = append(res, k)
+		}
+	}
+	return res
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"github.com/igevin/sepweb/pkg/context"
+	"strings"
+	"sync"
+)
+
+// RBAC 是基于角色的 Evaluator，角色可以继承其它角色的权限。
+// 权限可以带上 Condition，这时候只有条件全部满足才算有这个权限
+type RBAC struct {
+	mutex sync.RWMutex
+	roles map[string]*role
+}
+
+type role struct {
+	parents []string
+	grants  map[string][]Condition
+}
+
+func NewRBAC() *RBAC {
+	return &RBAC{roles: make(map[string]*role, 8)}
+}
+
+// Role 声明角色 name 继承 parents 的全部权限，
+// 拥有 name 的调用方也被认为拥有 parents 这些角色
+func (r *RBAC) Role(name string, parents ...string) *RBAC {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ro := r.role(name)
+	ro.parents = append(ro.parents, parents...)
+	return r
+}
+
+// Grant 把权限 perm 授予角色 name。perm 形如 "order:write"，
+// "order:*" 表示 order 的全部权限，"*" 表示所有权限
+func (r *RBAC) Grant(name, perm string, conds ...Condition) *RBAC {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ro := r.role(name)
+	ro.grants[perm] = append([]Condition(nil), conds...)
+	return r
+}
+
+func (r *RBAC) role(name string) *role {
+	ro, ok := r.roles[name]
+	if !ok {
+		ro = &role{grants: make(map[string][]Condition, 4)}
+		r.roles[name] = ro
+	}
+	return ro
+}
+
+func (r *RBAC) HasRole(p *Principal, name string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	found := false
+	r.walk(p, func(current string, _ *role) bool {
+		found = current == name
+		return found
+	})
+	return found
+}
+
+func (r *RBAC) HasPermission(ctx *context.Context, p *Principal, perm string) bool {
+	// Condition 是使用者提供的代码，可能会调用 Grant 或者 Role，所以要在释放锁之后执行
+	for _, conds := range r.grantsFor(p, perm) {
+		if checkConditions(ctx, p, conds) {
+			return true
+		}
+	}
+	return false
+}
+
+// grantsFor 返回调用方所有能匹配 perm 的授权各自的条件。
+// 有不带条件的授权的时候只返回它，因为不需要再检查别的条件了
+func (r *RBAC) grantsFor(p *Principal, perm string) [][]Condition {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	var res [][]Condition
+	r.walk(p, func(_ string, ro *role) bool {
+		if ro == nil {
+			return false
+		}
+		for granted, conds := range ro.grants {
+			if !matchPermission(granted, perm) {
+				continue
+			}
+			if len(conds) == 0 {
+				res = [][]Condition{nil}
+				return true
+			}
+			res = append(res, conds)
+		}
+		return false
+	})
+	return res
+}
+
+// walk 遍历调用方直接拥有以及继承得来的全部角色，fn 返回 true 的时候停止。
+// 继承关系里面有环也不会死循环。调用方需要持有读锁
+func (r *RBAC) walk(p *Principal, fn func(name string, ro *role) bool) {
+	visited := make(map[string]struct{}, len(p.Roles))
+	stack := append([]string(nil), p.Roles...)
+	for len(stack) > 0 {
+		name := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := visited[name]; ok {
+			continue
+		}
+		visited[name] = struct{}{}
+		ro := r.roles[name]
+		if fn(name, ro) {
+			return
+		}
+		if ro != nil {
+			stack = append(stack, ro.parents...)
+		}
+	}
+}
+
+func matchPermission(granted, perm string) bool {
+	if granted == "*" || granted == perm {
+		return true
+	}
+	if !strings.HasSuffix(granted, ":*") {
+		return false
+	}
+	return strings.HasPrefix(perm, granted[:len(granted)-1])
+}
+
+func checkConditions(ctx *context.Context, p *Principal, conds []Condition) bool {
+	for _, cond := range conds {
+		if !cond(ctx, p) {
+			return false
+		}
+	}
+	return true
+}
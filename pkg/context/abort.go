@@ -0,0 +1,29 @@
+package context
+
+// Abort 中断处理链路，后面的中间件和 handler 都不会再执行，
+// 但是已经执行过的中间件在 next 返回之后的逻辑依旧会执行
+func (c *Context) Abort() {
+	c.aborted = true
+}
+
+// AbortWithStatus 中断处理链路，并且设置响应码
+func (c *Context) AbortWithStatus(code int) {
+	c.RespStatusCode = code
+	c.Abort()
+}
+
+// AbortWithError 中断处理链路，设置响应码，并且记录中断的原因，
+// 外层的中间件（比如日志）可以通过 AbortError 拿到
+func (c *Context) AbortWithError(code int, err error) {
+	c.abortErr = err
+	c.AbortWithStatus(code)
+}
+
+func (c *Context) IsAborted() bool {
+	return c.aborted
+}
+
+// AbortError 返回 AbortWithError 记录的原因
+func (c *Context) AbortError() error {
+	return c.abortErr
+}
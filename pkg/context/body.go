@@ -0,0 +1,64 @@
+package context
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ErrBodyTooLarge 表示请求体超过了限制，对应 413 响应
+var ErrBodyTooLarge = errors.New("web: 请求体过大")
+
+// LimitBody 限制请求体最多只能读取 n 个字节。
+// 可以多次调用，每次都会在当前的请求体外面再套一层，最终生效的是最小的那个限制
+func (c *Context) LimitBody(n int64) {
+	if c.Req.Body == nil || c.Req.Body == http.NoBody {
+		return
+	}
+	c.Req.Body = &limitedBody{ReadCloser: http.MaxBytesReader(c.Resp, c.Req.Body, n)}
+}
+
+// Body 读取整个请求体并缓存起来，之后可以反复调用。
+// 读取之后 Req.Body 会被替换成缓存的数据，所以后面直接读 Req.Body 依旧能读到完整的内容。
+// 请求体超过 LimitBody 的限制时返回 ErrBodyTooLarge，并且把 RespStatusCode 设置为 413
+func (c *Context) Body() ([]byte, error) {
+	if c.bodyCached {
+		return c.body, nil
+	}
+	if c.Req.Body == nil || c.Req.Body == http.NoBody {
+		c.bodyCached = true
+		return nil, nil
+	}
+	data, err := io.ReadAll(c.Req.Body)
+	if err != nil {
+		if errors.Is(err, ErrBodyTooLarge) {
+			c.RespStatusCode = http.StatusRequestEntityTooLarge
+		}
+		return nil, err
+	}
+	_ = c.Req.Body.Close()
+	c.body = data
+	c.bodyCached = true
+	c.resetBody()
+	return data, nil
+}
+
+// resetBody 让 Req.Body 重新从缓存的第一个字节开始读
+func (c *Context) resetBody() {
+	c.Req.Body = io.NopCloser(bytes.NewReader(c.body))
+}
+
+// limitedBody 把 http.MaxBytesReader 超出限制时返回的错误转换成 ErrBodyTooLarge
+type limitedBody struct {
+	io.ReadCloser
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	n, err := l.ReadCloser.Read(p)
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		err = ErrBodyTooLarge
+	}
+	return n, err
+}
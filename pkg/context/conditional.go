@@ -0,0 +1,124 @@
+package context
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SetETag 设置响应的 ETag，etag 需要带引号，比如 "v1" 或者 W/"v1"
+func (c *Context) SetETag(etag string) {
+	c.Resp.Header().Set("ETag", etag)
+}
+
+// SetLastModified 设置响应的 Last-Modified，HTTP 的时间只精确到秒
+func (c *Context) SetLastModified(t time.Time) {
+	if t.IsZero() || t.Unix() == 0 {
+		return
+	}
+	c.Resp.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+}
+
+// CheckNotModified 根据响应里已经设置的 ETag 和 Last-Modified 检查 If-None-Match 和 If-Modified-Since。
+// 客户端的缓存依旧有效时，GET 和 HEAD 请求返回 304，其它请求返回 412，并且返回 true，
+// 这时候 handler 应该直接返回
+func (c *Context) CheckNotModified() bool {
+	header := c.Resp.Header()
+	safe := c.Req.Method == http.MethodGet || c.Req.Method == http.MethodHead
+	if inm := c.Req.Header.Get("If-None-Match"); inm != "" {
+		if !matchETag(inm, header.Get("ETag"), false) {
+			return false
+		}
+		if !safe {
+			c.preconditionFailed()
+			return true
+		}
+		c.notModified()
+		return true
+	}
+	if !safe {
+		return false
+	}
+	if modified(c.Req.Header.Get("If-Modified-Since"), header.Get("Last-Modified")) {
+		return false
+	}
+	c.notModified()
+	return true
+}
+
+// CheckPrecondition 根据响应里已经设置的 ETag 和 Last-Modified 检查 If-Match 和 If-Unmodified-Since，
+// 一般用来实现乐观锁：更新之前先设置资源当前的 ETag，然后调用它。
+// 条件不满足的时候返回 false 并且设置 412，这时候 handler 不应该继续修改资源
+func (c *Context) CheckPrecondition() bool {
+	header := c.Resp.Header()
+	if im := c.Req.Header.Get("If-Match"); im != "" {
+		if matchETag(im, header.Get("ETag"), true) {
+			return true
+		}
+		c.preconditionFailed()
+		return false
+	}
+	ius := c.Req.Header.Get("If-Unmodified-Since")
+	if ius == "" || !modified(ius, header.Get("Last-Modified")) {
+		return true
+	}
+	c.preconditionFailed()
+	return false
+}
+
+func (c *Context) notModified() {
+	// 304 不能带响应体，描述响应体的头部也没有意义了
+	header := c.Resp.Header()
+	header.Del("Content-Type")
+	header.Del("Content-Length")
+	c.RespStatusCode = http.StatusNotModified
+	c.RespData = nil
+}
+
+func (c *Context) preconditionFailed() {
+	c.RespStatusCode = http.StatusPreconditionFailed
+	c.RespData = nil
+}
+
+// matchETag 检查 etag 是否在 list 里。strong 为 true 时使用强比较，弱 ETag 永远不匹配
+func matchETag(list, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	list = strings.TrimSpace(list)
+	if list == "*" {
+		return true
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong {
+			if candidate == etag {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// modified 判断 lastModified 是否晚于 since，任意一个无法解析的时候都认为修改过
+func modified(since, lastModified string) bool {
+	if since == "" || lastModified == "" {
+		return true
+	}
+	s, err := http.ParseTime(since)
+	if err != nil {
+		return true
+	}
+	lm, err := http.ParseTime(lastModified)
+	if err != nil {
+		return true
+	}
+	return lm.After(s)
+}
@@ -0,0 +1,121 @@
+package context
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestContext_CheckNotModified(t *testing.T) {
+	lastModified := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		method   string
+		etag     string
+		header   map[string]string
+		want     bool
+		wantCode int
+	}{
+		{
+			name:     "etag matched",
+			method:   http.MethodGet,
+			etag:     `"v1"`,
+			header:   map[string]string{"If-None-Match": `"v0", "v1"`},
+			want:     true,
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "weak comparison",
+			method:   http.MethodGet,
+			etag:     `"v1"`,
+			header:   map[string]string{"If-None-Match": `W/"v1"`},
+			want:     true,
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:   "etag changed",
+			method: http.MethodGet,
+			etag:   `"v2"`,
+			// If-None-Match 存在的时候忽略 If-Modified-Since
+			header: map[string]string{"If-None-Match": `"v1"`, "If-Modified-Since": lastModified.Format(http.TimeFormat)},
+		},
+		{
+			name:     "not modified since",
+			method:   http.MethodGet,
+			header:   map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)},
+			want:     true,
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:   "modified since",
+			method: http.MethodGet,
+			header: map[string]string{"If-Modified-Since": lastModified.Add(-time.Second).Format(http.TimeFormat)},
+		},
+		{
+			name:     "unsafe method with star",
+			method:   http.MethodPut,
+			etag:     `"v1"`,
+			header:   map[string]string{"If-None-Match": "*"},
+			want:     true,
+			wantCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:   "no condition",
+			method: http.MethodGet,
+			etag:   `"v1"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/", nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			c := &Context{Req: req, Resp: httptest.NewRecorder(), RespData: []byte("data")}
+			c.SetLastModified(lastModified.Add(500 * time.Millisecond))
+			if tc.etag != "" {
+				c.SetETag(tc.etag)
+			}
+			assert.Equal(t, tc.want, c.CheckNotModified())
+			assert.Equal(t, tc.wantCode, c.RespStatusCode)
+		})
+	}
+}
+
+func TestContext_CheckPrecondition(t *testing.T) {
+	lastModified := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name   string
+		etag   string
+		header map[string]string
+		want   bool
+	}{
+		{name: "no condition", etag: `"v1"`, want: true},
+		{name: "matched", etag: `"v1"`, header: map[string]string{"If-Match": `"v1"`}, want: true},
+		{name: "star", etag: `"v1"`, header: map[string]string{"If-Match": "*"}, want: true},
+		{name: "changed", etag: `"v2"`, header: map[string]string{"If-Match": `"v1"`}},
+		// If-Match 使用强比较
+		{name: "weak", etag: `W/"v1"`, header: map[string]string{"If-Match": `W/"v1"`}},
+		{name: "unmodified", header: map[string]string{"If-Unmodified-Since": lastModified.Format(http.TimeFormat)}, want: true},
+		{name: "modified", header: map[string]string{"If-Unmodified-Since": lastModified.Add(-time.Hour).Format(http.TimeFormat)}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/", nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			c := &Context{Req: req, Resp: httptest.NewRecorder()}
+			c.SetLastModified(lastModified)
+			if tc.etag != "" {
+				c.SetETag(tc.etag)
+			}
+			assert.Equal(t, tc.want, c.CheckPrecondition())
+			if !tc.want {
+				assert.Equal(t, http.StatusPreconditionFailed, c.RespStatusCode)
+			}
+		})
+	}
+}
@@ -1,13 +1,24 @@
 package context
 
 import (
+	"bytes"
+	stdctx "context"
 	"encoding/json"
 	"errors"
+	"github.com/igevin/sepweb/pkg/keyring"
 	"github.com/igevin/sepweb/pkg/template"
+	"mime"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"sync/atomic"
+	"time"
 )
 
+// Context 同时实现了标准库的 context.Context，
+// 可以直接传给需要 context.Context 的下游调用
+var _ stdctx.Context = &Context{}
+
 type Context struct {
 	Req              *http.Request
 	Resp             http.ResponseWriter
@@ -17,26 +28,122 @@ type Context struct {
 	PathParams       map[string]string
 	cacheQueryValues url.Values
 	TplEngine        template.TemplateEngine
+	Keyring          *keyring.Keyring
+	Routes           RouteResolver
+	TrustedProxies   []netip.Prefix
 	UserValues       map[string]any
+
+	// std 是 Context 作为 context.Context 使用时读取的状态，见 stdState
+	std *stdState
+	// gen 是 Context 的代数，每次 Reset 和 Release 都会加一，跨越复用保持不变
+	gen *atomic.Uint64
+
+	// streamed 标记响应体已经通过 Stream 直接写回，flushResp 不应再写 RespData
+	streamed bool
+	// hijacked 标记底层连接已经被接管，比如升级成了 WebSocket
+	hijacked bool
+
+	aborted  bool
+	abortErr error
+
+	// body 是缓存的请求体，只有调用过 Body 之后才有
+	body       []byte
+	bodyCached bool
+}
+
+// stdState 可以被多个 goroutine 同时读取，Context 放回池子的时候会跟着复用。
+// values 是只读的 map，Set 的时候复制一份新的再整体替换
+type stdState struct {
+	values atomic.Pointer[map[any]any]
+	// escaped 标记 Context 已经被当作 context.Context 使用过，比如调用过 Done，
+	// 可能被其它 goroutine 持有，所以请求结束之后不能再放回池子
+	escaped atomic.Bool
+}
+
+func (c *Context) Deadline() (deadline time.Time, ok bool) {
+	c.markEscaped()
+	return c.stdContext().Deadline()
+}
+
+func (c *Context) Done() <-chan struct{} {
+	c.markEscaped()
+	return c.stdContext().Done()
+}
+
+func (c *Context) Err() error {
+	c.markEscaped()
+	return c.stdContext().Err()
+}
+
+// Value 先查找通过 Set 设置的值，找不到再到请求的 context 里找。
+// 模板函数、CSRF 这些常用的地方都会调用 Value，所以它不会阻止 Context 放回池子，
+// 请求结束之后还要读取值的话，应该使用 Context.Copy
+func (c *Context) Value(key any) any {
+	return c.value(key)
+}
+
+func (c *Context) value(key any) any {
+	if c.std != nil {
+		if values := c.std.values.Load(); values != nil {
+			if val, ok := (*values)[key]; ok {
+				return val
+			}
+		}
+	}
+	return c.stdContext().Value(key)
+}
+
+func (c *Context) markEscaped() {
+	if c.std != nil && !c.std.escaped.Load() {
+		c.std.escaped.Store(true)
+	}
+}
+
+func (c *Context) stdContext() stdctx.Context {
+	if c.Req == nil {
+		return stdctx.Background()
+	}
+	return c.Req.Context()
 }
 
+// BindJson 通过 Body 读取请求体，所以之后依旧可以拿到原始的请求体，比如用来验签
 func (c *Context) BindJson(val any) error {
-	data := c.Req.Body
-	if data == nil {
+	if c.Req.Body == nil {
 		return errors.New("body is empty")
 	}
-	decoder := json.NewDecoder(data)
+	data, err := c.Body()
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
 	decoder.DisallowUnknownFields()
 	return decoder.Decode(val)
 }
 
 func (c *Context) FormValue(key string) StringValue {
-	if err := c.Req.ParseForm(); err != nil {
+	if err := c.parseForm(); err != nil {
 		return StringValue{err: err}
 	}
 	return StringValue{val: c.Req.FormValue(key)}
 }
 
+// parseForm 解析表单。urlencoded 的请求体会先通过 Body 缓存下来，
+// 所以解析之后依旧可以拿到原始的请求体；multipart 的请求体可能很大，不会缓存
+func (c *Context) parseForm() error {
+	if c.Req.Form != nil {
+		return nil
+	}
+	ct, _, _ := mime.ParseMediaType(c.Req.Header.Get("Content-Type"))
+	if ct == "application/x-www-form-urlencoded" {
+		if _, err := c.Body(); err != nil {
+			return err
+		}
+		c.resetBody()
+		defer c.resetBody()
+	}
+	return c.Req.ParseForm()
+}
+
 func (c *Context) QueryValue(key string) StringValue {
 	if c.cacheQueryValues == nil {
 		c.cacheQueryValues = c.Req.URL.Query()
@@ -64,19 +171,20 @@ func (c *Context) RespJSONOK(val any) error {
 	return c.RespJSON(http.StatusOK, val)
 }
 
+// RespJSON 把 val 序列化之后放进 RespData，并且把 Content-Type 设置为 application/json，
+// 响应等到 flushResp 才会写回，所以外层的中间件还可以修改它
 func (c *Context) RespJSON(code int, val any) error {
 	bs, err := json.Marshal(val)
 	if err != nil {
 		return err
 	}
-	c.Resp.WriteHeader(code)
-	_, err = c.Resp.Write(bs)
-	return err
+	c.Blob(code, "application/json", bs)
+	return nil
 }
 
 func (c *Context) Render(tpl string, data any) error {
 	var err error
-	c.RespData, err = c.TplEngine.Render(c.Req.Context(), tpl, data)
+	c.RespData, err = c.TplEngine.Render(c, tpl, data)
 	c.RespStatusCode = http.StatusOK
 	if err != nil {
 		c.RespStatusCode = http.StatusInternalServerError
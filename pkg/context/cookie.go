@@ -0,0 +1,140 @@
+package context
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCookieTampered = errors.New("web: cookie 被篡改")
+	ErrCookieExpired  = errors.New("web: cookie 已经过期")
+	ErrNoKeyring      = errors.New("web: 没有配置 Keyring")
+)
+
+var cookieEncoding = base64.RawURLEncoding
+
+func (c *Context) Cookie(name string) StringValue {
+	cookie, err := c.Req.Cookie(name)
+	if err != nil {
+		return StringValue{err: err}
+	}
+	return StringValue{val: cookie.Value}
+}
+
+// SetSignedCookie 写一个带 HMAC 签名的 cookie，客户端可以看到值但是无法篡改。
+// cookie 的 MaxAge 或者 Expires 会被一起签进去，服务端会据此判断是否过期
+func (c *Context) SetSignedCookie(cookie *http.Cookie) error {
+	if c.Keyring == nil {
+		return ErrNoKeyring
+	}
+	expires := cookieExpires(cookie)
+	payload := cookieEncoding.EncodeToString([]byte(cookie.Value)) + "." + strconv.FormatInt(expires, 10)
+	mac, err := c.Keyring.Sign([]byte(cookie.Name + "|" + payload))
+	if err != nil {
+		return err
+	}
+	signed := *cookie
+	signed.Value = payload + "." + cookieEncoding.EncodeToString(mac)
+	c.SetCookie(&signed)
+	return nil
+}
+
+// SignedCookie 读取 SetSignedCookie 写入的 cookie，
+// 签名不对返回 ErrCookieTampered，过期返回 ErrCookieExpired
+func (c *Context) SignedCookie(name string) StringValue {
+	if c.Keyring == nil {
+		return StringValue{err: ErrNoKeyring}
+	}
+	cookie, err := c.Req.Cookie(name)
+	if err != nil {
+		return StringValue{err: err}
+	}
+	idx := strings.LastIndexByte(cookie.Value, '.')
+	if idx < 0 {
+		return StringValue{err: ErrCookieTampered}
+	}
+	payload := cookie.Value[:idx]
+	mac, err := cookieEncoding.DecodeString(cookie.Value[idx+1:])
+	if err != nil || !c.Keyring.Verify([]byte(name+"|"+payload), mac) {
+		return StringValue{err: ErrCookieTampered}
+	}
+	encodedVal, expiresStr, ok := strings.Cut(payload, ".")
+	if !ok {
+		return StringValue{err: ErrCookieTampered}
+	}
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return StringValue{err: ErrCookieTampered}
+	}
+	if expired(expires) {
+		return StringValue{err: ErrCookieExpired}
+	}
+	val, err := cookieEncoding.DecodeString(encodedVal)
+	if err != nil {
+		return StringValue{err: ErrCookieTampered}
+	}
+	return StringValue{val: string(val)}
+}
+
+// SetEncryptedCookie 写一个 AES-GCM 加密的 cookie，客户端既看不到也改不了它的值
+func (c *Context) SetEncryptedCookie(cookie *http.Cookie) error {
+	if c.Keyring == nil {
+		return ErrNoKeyring
+	}
+	plaintext := make([]byte, 8, 8+len(cookie.Value))
+	binary.BigEndian.PutUint64(plaintext, uint64(cookieExpires(cookie)))
+	plaintext = append(plaintext, cookie.Value...)
+	// 把 cookie 的名字作为附加数据，防止把一个 cookie 的值挪到另外一个 cookie 上用
+	ciphertext, err := c.Keyring.Encrypt(plaintext, []byte(cookie.Name))
+	if err != nil {
+		return err
+	}
+	encrypted := *cookie
+	encrypted.Value = cookieEncoding.EncodeToString(ciphertext)
+	c.SetCookie(&encrypted)
+	return nil
+}
+
+// EncryptedCookie 读取 SetEncryptedCookie 写入的 cookie，
+// 无法解密返回 ErrCookieTampered，过期返回 ErrCookieExpired
+func (c *Context) EncryptedCookie(name string) StringValue {
+	if c.Keyring == nil {
+		return StringValue{err: ErrNoKeyring}
+	}
+	cookie, err := c.Req.Cookie(name)
+	if err != nil {
+		return StringValue{err: err}
+	}
+	ciphertext, err := cookieEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return StringValue{err: ErrCookieTampered}
+	}
+	plaintext, err := c.Keyring.Decrypt(ciphertext, []byte(name))
+	if err != nil || len(plaintext) < 8 {
+		return StringValue{err: ErrCookieTampered}
+	}
+	if expired(int64(binary.BigEndian.Uint64(plaintext))) {
+		return StringValue{err: ErrCookieExpired}
+	}
+	return StringValue{val: string(plaintext[8:])}
+}
+
+// cookieExpires 返回 cookie 过期时间的 unix 时间戳，0 表示不过期
+func cookieExpires(cookie *http.Cookie) int64 {
+	if cookie.MaxAge > 0 {
+		return time.Now().Add(time.Duration(cookie.MaxAge) * time.Second).Unix()
+	}
+	if !cookie.Expires.IsZero() {
+		return cookie.Expires.Unix()
+	}
+	return 0
+}
+
+func expired(expires int64) bool {
+	return expires > 0 && time.Now().Unix() > expires
+}
@@ -0,0 +1,103 @@
+package context
+
+import (
+	"github.com/igevin/sepweb/pkg/keyring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestContext_SignedAndEncryptedCookie(t *testing.T) {
+	oldSecret := []byte("an old secret with enough bytes")
+	newSecret := []byte("a brand new secret with enough bytes")
+	kr, err := keyring.New(oldSecret)
+	require.NoError(t, err)
+
+	// roundTrip 先写 cookie，然后把响应里的 cookie 带到下一个请求里读出来
+	roundTrip := func(set func(c *Context) error, get func(c *Context) StringValue,
+		tamper func(cookie *http.Cookie)) (string, error) {
+		recorder := httptest.NewRecorder()
+		c := &Context{Resp: recorder, Keyring: kr}
+		require.NoError(t, set(c))
+		cookies := recorder.Result().Cookies()
+		require.Len(t, cookies, 1)
+		if tamper != nil {
+			tamper(cookies[0])
+		}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookies[0])
+		return get(&Context{Req: req, Keyring: kr}).ToString()
+	}
+
+	testCases := []struct {
+		name    string
+		cookie  *http.Cookie
+		tamper  func(cookie *http.Cookie)
+		wantVal string
+		wantErr error
+	}{
+		{
+			name:    "ok",
+			cookie:  &http.Cookie{Name: "user", Value: "Tom", MaxAge: 60},
+			wantVal: "Tom",
+		},
+		{
+			name:   "tampered",
+			cookie: &http.Cookie{Name: "user", Value: "Tom"},
+			tamper: func(cookie *http.Cookie) {
+				cookie.Value = "A" + cookie.Value[1:]
+			},
+			wantErr: ErrCookieTampered,
+		},
+		{
+			name:   "renamed",
+			cookie: &http.Cookie{Name: "user", Value: "Tom"},
+			tamper: func(cookie *http.Cookie) {
+				cookie.Name = "admin"
+			},
+			wantErr: http.ErrNoCookie,
+		},
+		{
+			name:    "expired",
+			cookie:  &http.Cookie{Name: "user", Value: "Tom", Expires: time.Now().Add(-time.Minute)},
+			wantErr: ErrCookieExpired,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			val, err := roundTrip(func(c *Context) error {
+				return c.SetSignedCookie(tc.cookie)
+			}, func(c *Context) StringValue {
+				return c.SignedCookie("user")
+			}, tc.tamper)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantVal, val)
+
+			val, err = roundTrip(func(c *Context) error {
+				return c.SetEncryptedCookie(tc.cookie)
+			}, func(c *Context) StringValue {
+				return c.EncryptedCookie("user")
+			}, tc.tamper)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantVal, val)
+		})
+	}
+
+	// 轮换密钥之后，旧密钥签发的 cookie 依旧有效，直到旧密钥被淘汰
+	recorder := httptest.NewRecorder()
+	c := &Context{Resp: recorder, Keyring: kr}
+	require.NoError(t, c.SetSignedCookie(&http.Cookie{Name: "user", Value: "Tom"}))
+	require.NoError(t, kr.Rotate(newSecret))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(recorder.Result().Cookies()[0])
+	val, err := (&Context{Req: req, Keyring: kr}).SignedCookie("user").ToString()
+	assert.NoError(t, err)
+	assert.Equal(t, "Tom", val)
+	kr.Retain(1)
+	_, err = (&Context{Req: req, Keyring: kr}).SignedCookie("user").ToString()
+	assert.Equal(t, ErrCookieTampered, err)
+}
@@ -0,0 +1,53 @@
+package context
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ClientIP 返回客户端的 IP。只有当直接连过来的是 TrustedProxies 里的代理时，
+// 才会相信 X-Forwarded-For 和 X-Real-IP，否则任何人都可以伪造自己的 IP
+func (c *Context) ClientIP() string {
+	remote := remoteIP(c.Req.RemoteAddr)
+	if !c.trustedProxy(remote) {
+		return remote
+	}
+	// 从右往左找第一个不是可信代理的地址，它才是真正的客户端
+	if xff := c.Req.Header.Get("X-Forwarded-For"); xff != "" {
+		ips := strings.Split(xff, ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(ips[i])
+			if !c.trustedProxy(ip) {
+				return ip
+			}
+		}
+		return strings.TrimSpace(ips[0])
+	}
+	if ip := c.Req.Header.Get("X-Real-IP"); ip != "" {
+		return ip
+	}
+	return remote
+}
+
+func (c *Context) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range c.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,51 @@
+package context
+
+// Key 是存放在 Context 上的值的键。
+// 每次调用 NewKey 都会得到一个不同的键，所以不同的中间件即便用了同样的名字也不会互相覆盖
+type Key[T any] struct {
+	name string
+}
+
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+func (k *Key[T]) String() string {
+	return "web: context key " + k.name
+}
+
+// Set 把 val 存放到 Context 上，之后可以通过 Get 或者 Context.Value 取出来。
+// 其它 goroutine 可以同时通过 Value 读取
+func Set[T any](c *Context, key *Key[T], val T) {
+	if c.std == nil {
+		c.std = &stdState{}
+	}
+	for {
+		old := c.std.values.Load()
+		values := make(map[any]any, 4)
+		if old != nil {
+			for k, v := range *old {
+				values[k] = v
+			}
+		}
+		values[key] = val
+		if c.std.values.CompareAndSwap(old, &values) {
+			return
+		}
+	}
+}
+
+// Get 取出 key 对应的值。Context 上没有的话，会继续到请求的 context 里找
+func Get[T any](c *Context, key *Key[T]) (T, bool) {
+	val, ok := c.value(key).(T)
+	return val, ok
+}
+
+// MustGet 和 Get 一样，但是找不到的时候会 panic
+func MustGet[T any](c *Context, key *Key[T]) T {
+	val, ok := Get(c, key)
+	if !ok {
+		panic("web: 找不到 " + key.name)
+	}
+	return val
+}
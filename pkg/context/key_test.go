@@ -0,0 +1,87 @@
+package context
+
+import (
+	stdctx "context"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type ctxKey struct{}
+
+func TestContext_Value(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	reqCtx, cancel := stdctx.WithTimeout(stdctx.WithValue(req.Context(), ctxKey{}, "from request"), time.Minute)
+	defer cancel()
+	c := &Context{Req: req.WithContext(reqCtx)}
+
+	userKey := NewKey[string]("user")
+	// 同名的键也不会冲突
+	otherUserKey := NewKey[int]("user")
+	Set(c, userKey, "Tom")
+	Set(c, otherUserKey, 18)
+
+	user, ok := Get(c, userKey)
+	assert.True(t, ok)
+	assert.Equal(t, "Tom", user)
+	assert.Equal(t, 18, MustGet(c, otherUserKey))
+
+	_, ok = Get(c, NewKey[string]("missing"))
+	assert.False(t, ok)
+	assert.Panics(t, func() {
+		MustGet(c, NewKey[string]("missing"))
+	})
+
+	// 作为 context.Context 使用
+	var std stdctx.Context = c
+	assert.Equal(t, "from request", std.Value(ctxKey{}))
+	assert.Equal(t, "Tom", std.Value(userKey))
+	_, hasDeadline := std.Deadline()
+	assert.True(t, hasDeadline)
+	assert.Nil(t, std.Err())
+	cancel()
+	<-std.Done()
+	assert.Equal(t, stdctx.Canceled, std.Err())
+}
+
+func TestContext_Release(t *testing.T) {
+	key := NewKey[int]("counter")
+	c := &Context{}
+	c.Reset(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	Set(c, key, 0)
+
+	// 其它 goroutine 读取的同时设置新的值
+	var std stdctx.Context = c
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_, _ = std.Value(key).(int)
+			_ = std.Err()
+		}
+	}()
+	for i := 1; i <= 100; i++ {
+		Set(c, key, i)
+	}
+	<-done
+
+	// 被当作 context.Context 用过，不能再放回池子，Req 和值也都保持不变
+	req := c.Req
+	assert.False(t, c.Release())
+	assert.Same(t, req, c.Req)
+	assert.Equal(t, 100, std.Value(key))
+	_, err := c.Resp.Write([]byte("late"))
+	assert.Equal(t, ErrContextReleased, err)
+
+	// 只通过 Value 读取过的 Context 可以放回池子，复用的时候看不到上一个请求的值
+	c = &Context{}
+	c.Reset(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	Set(c, key, 1)
+	assert.Equal(t, 1, MustGet(c, key))
+	assert.Equal(t, 1, c.Value(key))
+	assert.True(t, c.Release())
+	assert.Nil(t, c.Req)
+	c.Reset(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Nil(t, c.Value(key))
+}
@@ -0,0 +1,172 @@
+package context
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+var ErrContextReleased = errors.New("web: 请求已经结束，不能再写响应，请使用 Context.Copy")
+
+// Reset 让 Context 可以被复用来处理新的请求，所有字段都会恢复成零值。
+// Resp 会包装一层，请求结束之后通过它写的数据都会被丢弃，见 guardedWriter。
+// guardedWriter 可能被上一个请求的 SSE 之类的持有，所以每个请求都要创建新的
+func (c *Context) Reset(w http.ResponseWriter, r *http.Request) {
+	gen, std := c.gen, c.std
+	if gen == nil {
+		gen = new(atomic.Uint64)
+	}
+	if std == nil {
+		std = &stdState{}
+	}
+	*c = Context{
+		Req:  r,
+		Resp: &guardedWriter{ResponseWriter: w, gen: gen, want: gen.Add(1)},
+		std:  std,
+		gen:  gen,
+	}
+}
+
+// Release 在请求处理完之后调用，返回 Context 能不能放回池子。
+//
+// Context 被当作 context.Context 用过的话（调用过 Deadline、Done 或者 Err），
+// 它可能被其它 goroutine 持有，比如 http.Client 的连接，
+// 这时候 Release 只会替换 Resp，Req 和通过 Set 设置的值都保持不变，并且返回 false。
+// 所以交给其它 goroutine 之后还要继续使用的 Context，至少要在请求结束之前被当作 context.Context 用过一次，
+// 否则应该交给它 Context.Copy 的结果
+//
+// 请求结束之后，提前取出来的 Resp（包括基于它的 SSE 和 Stream）写的数据都会被丢弃。
+// 但是放回池子的 Context 会被下一个请求复用，之后再通过这个 Context 访问的就是新的请求了，
+// 所以 handler 返回之后还要使用 Context 的话，一定要用 Context.Copy
+func (c *Context) Release() bool {
+	if c.gen != nil {
+		c.gen.Add(1)
+	}
+	if c.std != nil && c.std.escaped.Load() {
+		c.Resp = releasedResponseWriter{}
+		return false
+	}
+	if c.std != nil {
+		c.std.values.Store(nil)
+	}
+	*c = Context{Resp: releasedResponseWriter{}, gen: c.gen, std: c.std}
+	return true
+}
+
+// Copy 返回一个 Context 的副本，可以在 handler 返回之后继续使用，
+// 比如交给另外一个 goroutine 做异步处理。副本不能再写响应
+func (c *Context) Copy() *Context {
+	res := *c
+	res.Resp = releasedResponseWriter{}
+	res.gen = nil
+	if c.PathParams != nil {
+		res.PathParams = make(map[string]string, len(c.PathParams))
+		for k, v := range c.PathParams {
+			res.PathParams[k] = v
+		}
+	}
+	// values 是只读的，副本可以直接共用，之后各自 Set 的时候都会复制一份
+	res.std = &stdState{}
+	if c.std != nil {
+		res.std.values.Store(c.std.values.Load())
+	}
+	if c.UserValues != nil {
+		res.UserValues = make(map[string]any, len(c.UserValues))
+		for k, v := range c.UserValues {
+			res.UserValues[k] = v
+		}
+	}
+	return &res
+}
+
+// Restore 用 Copy 得到的副本的处理结果覆盖当前的 Context，Resp 保持不变。
+// 一般是把 handler 放到副本上执行，确认它按时完成之后再同步回来
+func (c *Context) Restore(cp *Context) {
+	resp, std, gen := c.Resp, c.std, c.gen
+	*c = *cp
+	c.Resp, c.gen = resp, gen
+	// 当前的 Context 可能已经被别的 goroutine 持有，只能替换 values，不能换掉整个 std
+	c.std = std
+	if c.std == nil {
+		c.std = &stdState{}
+	}
+	c.std.values.Store(cp.std.values.Load())
+	if cp.std.escaped.Load() {
+		c.std.escaped.Store(true)
+	}
+}
+
+// guardedWriter 记住创建它的时候 Context 的代数，
+// Context 被 Release 或者 Reset 之后代数变了，它就不再写到底层的 ResponseWriter 上
+type guardedWriter struct {
+	http.ResponseWriter
+	gen  *atomic.Uint64
+	want uint64
+}
+
+func (g *guardedWriter) released() bool {
+	return g.gen.Load() != g.want
+}
+
+func (g *guardedWriter) Header() http.Header {
+	if g.released() {
+		return http.Header{}
+	}
+	return g.ResponseWriter.Header()
+}
+
+func (g *guardedWriter) Write(data []byte) (int, error) {
+	if g.released() {
+		log.Println(ErrContextReleased)
+		return 0, ErrContextReleased
+	}
+	return g.ResponseWriter.Write(data)
+}
+
+func (g *guardedWriter) WriteHeader(statusCode int) {
+	if g.released() {
+		log.Println(ErrContextReleased)
+		return
+	}
+	g.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (g *guardedWriter) Flush() {
+	if f, ok := g.ResponseWriter.(http.Flusher); ok && !g.released() {
+		f.Flush()
+	}
+}
+
+func (g *guardedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if g.released() {
+		return nil, nil, ErrContextReleased
+	}
+	if hj, ok := g.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap 让 http.ResponseController 可以找到底层的 ResponseWriter
+func (g *guardedWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// releasedResponseWriter 用来发现在请求结束之后仍然试图写响应的代码
+type releasedResponseWriter struct{}
+
+func (r releasedResponseWriter) Header() http.Header {
+	return http.Header{}
+}
+
+func (r releasedResponseWriter) Write(data []byte) (int, error) {
+	log.Println(ErrContextReleased)
+	return 0, ErrContextReleased
+}
+
+func (r releasedResponseWriter) WriteHeader(statusCode int) {
+	log.Println(ErrContextReleased)
+}
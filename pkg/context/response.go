@@ -0,0 +1,111 @@
+package context
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RouteResolver 根据路由的名字和路径参数生成 URL
+type RouteResolver interface {
+	URL(name string, params map[string]string) (string, error)
+}
+
+// Redirect 重定向到 url，code 必须是 3xx
+func (c *Context) Redirect(code int, url string) error {
+	if code < http.StatusMultipleChoices || code > http.StatusPermanentRedirect {
+		return fmt.Errorf("web: 非法的重定向响应码 %d", code)
+	}
+	c.Resp.Header().Set("Location", url)
+	c.RespStatusCode = code
+	c.RespData = nil
+	return nil
+}
+
+// RedirectToRoute 重定向到名字为 name 的路由
+func (c *Context) RedirectToRoute(code int, name string, params map[string]string) error {
+	if c.Routes == nil {
+		return errors.New("web: 没有配置路由，无法根据名字生成 URL")
+	}
+	url, err := c.Routes.URL(name, params)
+	if err != nil {
+		return err
+	}
+	return c.Redirect(code, url)
+}
+
+// File 把文件的内容作为响应，Content-Type 根据扩展名或者文件内容推断。
+// 整个文件会读进 RespData，也不支持 Range，所以只适合小文件。
+// 大文件应该用 Stream 或者直接用 http.ServeContent 写回
+func (c *Context) File(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.RespStatusCode = http.StatusNotFound
+		} else {
+			c.RespStatusCode = http.StatusInternalServerError
+		}
+		return err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	c.Blob(http.StatusOK, contentType, data)
+	return nil
+}
+
+// Attachment 让浏览器把文件下载下来，name 是下载时使用的文件名，可以包含非 ASCII 字符
+func (c *Context) Attachment(path string, name string) error {
+	if err := c.File(path); err != nil {
+		return err
+	}
+	c.Resp.Header().Set("Content-Disposition", contentDisposition("attachment", name))
+	return nil
+}
+
+func (c *Context) NoContent(code int) {
+	c.RespStatusCode = code
+	c.RespData = nil
+}
+
+func (c *Context) Blob(code int, contentType string, data []byte) {
+	c.Resp.Header().Set("Content-Type", contentType)
+	c.RespStatusCode = code
+	c.RespData = data
+}
+
+func (c *Context) String(code int, format string, args ...any) {
+	c.Blob(code, "text/plain; charset=utf-8", []byte(fmt.Sprintf(format, args...)))
+}
+
+// contentDisposition 按照 RFC 6266 生成 Content-Disposition，
+// filename 给不认识 filename* 的老客户端兜底，只保留 ASCII 字符
+func contentDisposition(typ, name string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r > 0x7e || r < 0x20 || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, typ, fallback, encodeRFC5987(name))
+}
+
+// encodeRFC5987 只保留 RFC 5987 里的 attr-char，其余字节都做百分号编码
+func encodeRFC5987(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') ||
+			strings.IndexByte("!#$&+-.^_`|~", b) >= 0 {
+			sb.WriteByte(b)
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("%%%02X", b))
+	}
+	return sb.String()
+}
@@ -0,0 +1,146 @@
+package context
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type mockResolver map[string]string
+
+func (m mockResolver) URL(name string, params map[string]string) (string, error) {
+	return m[name] + "/" + params["id"], nil
+}
+
+func TestContext_Response(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "report.csv")
+	assert.NoError(t, os.WriteFile(file, []byte("a,b\n"), 0o644))
+
+	testCases := []struct {
+		name       string
+		respond    func(c *Context) error
+		wantCode   int
+		wantHeader http.Header
+		wantData   string
+		wantErr    bool
+	}{
+		{
+			name: "redirect",
+			respond: func(c *Context) error {
+				return c.Redirect(http.StatusFound, "/login")
+			},
+			wantCode:   http.StatusFound,
+			wantHeader: http.Header{"Location": {"/login"}},
+		},
+		{
+			name: "redirect with bad code",
+			respond: func(c *Context) error {
+				return c.Redirect(http.StatusOK, "/login")
+			},
+			wantHeader: http.Header{},
+			wantErr:    true,
+		},
+		{
+			name: "redirect to route",
+			respond: func(c *Context) error {
+				c.Routes = mockResolver{"user": "/users"}
+				return c.RedirectToRoute(http.StatusSeeOther, "user", map[string]string{"id": "1"})
+			},
+			wantCode:   http.StatusSeeOther,
+			wantHeader: http.Header{"Location": {"/users/1"}},
+		},
+		{
+			name: "file",
+			respond: func(c *Context) error {
+				return c.File(file)
+			},
+			wantCode:   http.StatusOK,
+			wantHeader: http.Header{"Content-Type": {"text/csv; charset=utf-8"}},
+			wantData:   "a,b\n",
+		},
+		{
+			name: "file not found",
+			respond: func(c *Context) error {
+				return c.File(filepath.Join(dir, "missing.csv"))
+			},
+			wantCode:   http.StatusNotFound,
+			wantHeader: http.Header{},
+			wantErr:    true,
+		},
+		{
+			name: "attachment",
+			respond: func(c *Context) error {
+				return c.Attachment(file, "报表 2022.csv")
+			},
+			wantCode: http.StatusOK,
+			wantHeader: http.Header{
+				"Content-Type": {"text/csv; charset=utf-8"},
+				"Content-Disposition": {`attachment; filename="__ 2022.csv"; ` +
+					`filename*=UTF-8''%E6%8A%A5%E8%A1%A8%202022.csv`},
+			},
+			wantData: "a,b\n",
+		},
+		{
+			name: "no content",
+			respond: func(c *Context) error {
+				c.RespData = []byte("dropped")
+				c.NoContent(http.StatusNoContent)
+				return nil
+			},
+			wantCode:   http.StatusNoContent,
+			wantHeader: http.Header{},
+		},
+		{
+			name: "string",
+			respond: func(c *Context) error {
+				c.String(http.StatusAccepted, "hello, %s", "Tom")
+				return nil
+			},
+			wantCode:   http.StatusAccepted,
+			wantHeader: http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+			wantData:   "hello, Tom",
+		},
+		{
+			name: "json",
+			respond: func(c *Context) error {
+				return c.RespJSON(http.StatusCreated, map[string]string{"name": "Tom"})
+			},
+			wantCode:   http.StatusCreated,
+			wantHeader: http.Header{"Content-Type": {"application/json"}},
+			wantData:   `{"name":"Tom"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c := &Context{Resp: recorder}
+			err := tc.respond(c)
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantCode, c.RespStatusCode)
+			assert.Equal(t, tc.wantHeader, recorder.Header())
+			assert.Equal(t, tc.wantData, string(c.RespData))
+			// 所有的响应都只是设置好 RespData，还没有真正写回
+			assert.False(t, recorder.Flushed)
+			assert.Equal(t, 0, recorder.Body.Len())
+		})
+	}
+}
+
+func TestSSEWriter_Send(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c := &Context{Req: httptest.NewRequest(http.MethodGet, "/", nil), Resp: recorder}
+	w := c.SSE()
+	require.NoError(t, w.Send(SSEEvent{ID: "1", Event: "update", Data: "a\r\nb\rc\nd"}))
+	require.NoError(t, w.Comment("ping\rdata: injected"))
+	// 换行会让客户端把后面的内容当成新的字段
+	assert.Equal(t, ErrInvalidSSEField, w.Send(SSEEvent{ID: "1\ndata: injected", Data: "x"}))
+	assert.Equal(t, ErrInvalidSSEField, w.Send(SSEEvent{Event: "update\revent: other", Data: "x"}))
+	assert.Equal(t, "id: 1\nevent: update\ndata: a\ndata: b\ndata: c\ndata: d\n\n"+
+		": ping\n: data: injected\n\n", recorder.Body.String())
+}
@@ -0,0 +1,136 @@
+package context
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidSSEField 表示事件的 ID 或者名字里有换行，写出去会被客户端当成别的字段
+var ErrInvalidSSEField = errors.New("web: SSE 事件的 ID 和名字不能包含换行")
+
+// SSEEvent 是一条 Server-Sent Events 事件
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry 告诉客户端断线后隔多久重连，0 表示不设置
+	Retry time.Duration
+}
+
+// SSEWriter 按照 text/event-stream 格式把事件写回给客户端。
+// 它可以被多个 goroutine 同时使用
+type SSEWriter struct {
+	// resp 和 req 在创建的时候取出来，请求结束之后 Context 可能被复用，
+	// 这时候通过 resp 写的数据都会被丢弃，而不是写到别的请求里
+	resp http.ResponseWriter
+	req  *http.Request
+	mu   sync.Mutex
+}
+
+// SSE 把响应切换成 Server-Sent Events 模式，响应码固定是 200。
+// 调用之后 RespData 不再生效
+func (c *Context) SSE() *SSEWriter {
+	header := c.Resp.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// 避免 nginx 之类的反向代理缓冲事件
+	header.Set("X-Accel-Buffering", "no")
+	c.streamed = true
+	c.RespStatusCode = http.StatusOK
+	c.Resp.WriteHeader(http.StatusOK)
+	c.Flush()
+	return &SSEWriter{resp: c.Resp, req: c.Req}
+}
+
+// LastEventID 是客户端重连时带上来的最后一个事件 ID
+func (s *SSEWriter) LastEventID() string {
+	return s.req.Header.Get("Last-Event-ID")
+}
+
+// Done 在客户端断开连接的时候关闭
+func (s *SSEWriter) Done() <-chan struct{} {
+	return s.req.Context().Done()
+}
+
+func (s *SSEWriter) Send(e SSEEvent) error {
+	if strings.ContainsAny(e.ID, "\r\n") || strings.ContainsAny(e.Event, "\r\n") {
+		return ErrInvalidSSEField
+	}
+	var sb strings.Builder
+	if e.ID != "" {
+		sb.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		sb.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		sb.WriteString(fmt.Sprintf("retry: %d\n", e.Retry.Milliseconds()))
+	}
+	// 多行数据每一行都要单独加 data: 前缀
+	for _, line := range splitLines(e.Data) {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+	return s.write(sb.String())
+}
+
+// Comment 写一行注释，客户端会忽略它，一般用来做心跳
+func (s *SSEWriter) Comment(text string) error {
+	var sb strings.Builder
+	for _, line := range splitLines(text) {
+		sb.WriteString(": " + line + "\n")
+	}
+	sb.WriteString("\n")
+	return s.write(sb.String())
+}
+
+// splitLines 按照 SSE 的规则分行，CRLF、CR 和 LF 都是换行
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.Split(strings.ReplaceAll(text, "\r", "\n"), "\n")
+}
+
+// Serve 把 events 里的事件逐个写回，并按照 heartbeat 的间隔发送心跳，
+// 直到 events 被关闭或者客户端断开连接。heartbeat 为 0 表示不发送心跳
+func (s *SSEWriter) Serve(events <-chan SSEEvent, heartbeat time.Duration) error {
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-s.Done():
+			return s.req.Context().Err()
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := s.Send(e); err != nil {
+				return err
+			}
+		case <-tick:
+			if err := s.Comment("ping"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *SSEWriter) write(data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.resp.Write([]byte(data)); err != nil {
+		return err
+	}
+	if f, ok := s.resp.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package context
+
+import (
+	"io"
+	"net/http"
+)
+
+// Stream 绕过 RespData 的缓冲，直接把响应写回给客户端，
+// 适合大文件导出、长轮询之类无法一次性放进内存的场景。
+// 调用之后 RespData 不再生效，RespStatusCode 记录的是实际写回的响应码。
+func (c *Context) Stream(contentType string, fn func(w io.Writer) error) error {
+	if contentType != "" {
+		c.Resp.Header().Set("Content-Type", contentType)
+	}
+	if c.RespStatusCode == 0 {
+		c.RespStatusCode = http.StatusOK
+	}
+	c.streamed = true
+	c.Resp.WriteHeader(c.RespStatusCode)
+	return fn(c.Resp)
+}
+
+// Flush 把已经写入的数据立刻推送给客户端，
+// 底层的 http.ResponseWriter 不支持 http.Flusher 时什么也不做
+func (c *Context) Flush() {
+	if f, ok := c.Resp.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Streamed 返回响应体是否已经通过 Stream 写回
+func (c *Context) Streamed() bool {
+	return c.streamed
+}
@@ -0,0 +1,32 @@
+package context
+
+import (
+	"errors"
+	"github.com/igevin/sepweb/pkg/websocket"
+	"net/http"
+)
+
+// UpgradeWebSocket 把当前请求升级成 WebSocket 连接。
+// 握手失败的时候会设置好 RespStatusCode，由 flushResp 正常写回响应
+func (c *Context) UpgradeWebSocket(opts ...websocket.Option) (*websocket.Conn, error) {
+	conn, err := websocket.Upgrade(c.Resp, c.Req, opts...)
+	if err != nil {
+		var he *websocket.HandshakeError
+		if errors.As(err, &he) {
+			c.RespStatusCode = he.Status
+			c.RespData = []byte(http.StatusText(he.Status))
+		} else {
+			// Hijack 之后才出的错，连接已经不归 http.Server 管了
+			c.hijacked = true
+		}
+		return nil, err
+	}
+	c.hijacked = true
+	c.RespStatusCode = http.StatusSwitchingProtocols
+	return conn, nil
+}
+
+// Hijacked 返回底层连接是否已经被接管
+func (c *Context) Hijacked() bool {
+	return c.hijacked
+}
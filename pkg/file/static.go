@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type StaticResourceHandlerOption func(handler *StaticResourceHandler)
@@ -21,12 +22,15 @@ type StaticResourceHandler struct {
 
 	cache       *lru.Cache
 	maxFileSize int
+	// cacheObserver 在每次查找缓存之后被调用，用来统计命中率
+	cacheObserver func(hit bool)
 }
 
 type fileCacheItem struct {
 	fileName    string
 	fileSize    int
 	contentType string
+	modTime     time.Time
 	data        []byte
 }
 
@@ -60,6 +64,13 @@ func WithFileCache(maxFileSizeThreshold int, maxCacheFileCnt int) StaticResource
 	}
 }
 
+// WithCacheObserver 设置缓存查找的回调，hit 表示是否命中。只有开启了缓存才会被调用
+func WithCacheObserver(fn func(hit bool)) StaticResourceHandlerOption {
+	return func(h *StaticResourceHandler) {
+		h.cacheObserver = fn
+	}
+}
+
 func WithMoreExtension(extMap map[string]string) StaticResourceHandlerOption {
 	return func(h *StaticResourceHandler) {
 		for ext, contentType := range extMap {
@@ -72,35 +83,42 @@ func (s *StaticResourceHandler) Handler(ctx *context.Context) {
 	req, _ := ctx.PathValue("file").ToString()
 	if item, ok := s.readFileFromData(req); ok {
 		log.Printf("从缓存中读取数据...")
-		s.writeFileAsResponse(item, ctx.Resp)
+		s.writeFileAsResponse(item, ctx)
 		return
 	}
 	path := filepath.Join(s.dir, req)
 	f, err := os.Open(path)
 	if err != nil {
-		ctx.Resp.WriteHeader(http.StatusInternalServerError)
+		ctx.RespStatusCode = http.StatusInternalServerError
 		return
 	}
+	defer f.Close()
 	ext := getFileExt(f.Name())
 	t, ok := s.extensionContentTypeMap[ext]
 	if !ok {
-		ctx.Resp.WriteHeader(http.StatusBadRequest)
+		ctx.RespStatusCode = http.StatusBadRequest
 		return
 	}
 
+	info, err := f.Stat()
+	if err != nil {
+		ctx.RespStatusCode = http.StatusInternalServerError
+		return
+	}
 	data, err := ioutil.ReadAll(f)
 	if err != nil {
-		ctx.Resp.WriteHeader(http.StatusInternalServerError)
+		ctx.RespStatusCode = http.StatusInternalServerError
 		return
 	}
 	item := &fileCacheItem{
 		fileSize:    len(data),
 		data:        data,
 		contentType: t,
+		modTime:     info.ModTime(),
 		fileName:    req,
 	}
 	s.cacheFile(item)
-	s.writeFileAsResponse(item, ctx.Resp)
+	s.writeFileAsResponse(item, ctx)
 }
 
 func (s *StaticResourceHandler) cacheFile(item *fileCacheItem) {
@@ -110,19 +128,27 @@ func (s *StaticResourceHandler) cacheFile(item *fileCacheItem) {
 }
 
 func (s *StaticResourceHandler) readFileFromData(fileName string) (*fileCacheItem, bool) {
-	if s.cache != nil {
-		if item, ok := s.cache.Get(fileName); ok {
-			return item.(*fileCacheItem), true
-		}
+	if s.cache == nil {
+		return nil, false
+	}
+	item, ok := s.cache.Get(fileName)
+	if s.cacheObserver != nil {
+		s.cacheObserver(ok)
+	}
+	if !ok {
+		return nil, false
 	}
-	return nil, false
+	return item.(*fileCacheItem), true
 }
 
-func (s *StaticResourceHandler) writeFileAsResponse(item *fileCacheItem, writer http.ResponseWriter) {
-	writer.WriteHeader(http.StatusOK)
-	writer.Header().Set("Content-Type", item.contentType)
-	writer.Header().Set("Content-Length", fmt.Sprintf("%d", item.fileSize))
-	_, _ = writer.Write(item.data)
+// writeFileAsResponse 客户端缓存的文件没有变化的时候只返回 304
+func (s *StaticResourceHandler) writeFileAsResponse(item *fileCacheItem, ctx *context.Context) {
+	ctx.SetLastModified(item.modTime)
+	if ctx.CheckNotModified() {
+		return
+	}
+	ctx.Resp.Header().Set("Content-Length", fmt.Sprintf("%d", item.fileSize))
+	ctx.Blob(http.StatusOK, item.contentType, item.data)
 }
 
 func getFileExt(name string) string {
@@ -131,4 +157,4 @@ func getFileExt(name string) string {
 		return ""
 	}
 	return name[index+1:]
-}
\ No newline at end of file
+}
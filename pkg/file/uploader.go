@@ -65,11 +65,11 @@ func (u *Uploader) HandleFunc(ctx *context.Context) {
 func (u *Uploader) uploadFailedForNoData(ctx *context.Context, err error) {
 	ctx.RespStatusCode = http.StatusBadRequest
 	ctx.RespData = []byte("上传失败，未找到数据")
-	log.Fatalln(err)
+	log.Println("web: 上传失败，未找到数据", err)
 }
 
 func (u *Uploader) failToUpload(ctx *context.Context, err error) {
 	ctx.RespStatusCode = http.StatusInternalServerError
 	ctx.RespData = []byte("上传失败")
-	log.Fatalln(err)
+	log.Println("web: 上传失败", err)
 }
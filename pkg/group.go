@@ -0,0 +1,70 @@
+package sepweb
+
+import (
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"net/http"
+	"strings"
+)
+
+// Group 是一组有共同前缀和中间件的路由。
+// 组的中间件和路由自己的中间件一样，在路由匹配之后执行，组的在前
+type Group struct {
+	server *HttpServer
+	prefix string
+	mdls   []middleware.Middleware
+}
+
+// Group 创建一个路由组，prefix 必须以 / 开头，不能以 / 结尾
+func (s *HttpServer) Group(prefix string, mdls ...middleware.Middleware) *Group {
+	checkGroupPrefix(prefix)
+	return &Group{server: s, prefix: prefix, mdls: mdls}
+}
+
+// Group 创建一个子路由组，它会继承当前组的前缀和中间件
+func (g *Group) Group(prefix string, mdls ...middleware.Middleware) *Group {
+	checkGroupPrefix(prefix)
+	return &Group{server: g.server, prefix: g.prefix + prefix, mdls: g.with(mdls)}
+}
+
+// Use 给组追加中间件，只对之后注册的路由生效
+func (g *Group) Use(mdls ...middleware.Middleware) {
+	g.mdls = append(g.mdls, mdls...)
+}
+
+func (g *Group) AddRoute(method, path string, handle handler.Handle, mdls ...middleware.Middleware) {
+	g.server.AddRoute(method, g.path(path), handle, g.with(mdls)...)
+}
+
+func (g *Group) AddNamedRoute(name, method, path string, handle handler.Handle, mdls ...middleware.Middleware) {
+	g.server.AddNamedRoute(name, method, g.path(path), handle, g.with(mdls)...)
+}
+
+func (g *Group) Get(path string, handle handler.Handle, mdls ...middleware.Middleware) {
+	g.AddRoute(http.MethodGet, path, handle, mdls...)
+}
+
+func (g *Group) Post(path string, handle handler.Handle, mdls ...middleware.Middleware) {
+	g.AddRoute(http.MethodPost, path, handle, mdls...)
+}
+
+// path 拼接前缀，组里的 / 对应的就是前缀本身
+func (g *Group) path(path string) string {
+	if path == "/" {
+		return g.prefix
+	}
+	return g.prefix + path
+}
+
+// with 返回组的中间件加上 mdls，每次都复制一份，避免不同的路由共用底层数组
+func (g *Group) with(mdls []middleware.Middleware) []middleware.Middleware {
+	res := make([]middleware.Middleware, 0, len(g.mdls)+len(mdls))
+	res = append(res, g.mdls...)
+	return append(res, mdls...)
+}
+
+func checkGroupPrefix(prefix string) {
+	if prefix == "" || prefix[0] != '/' || strings.HasSuffix(prefix, "/") {
+		panic("web: 路由组的前缀必须以 / 开头，并且不能以 / 结尾")
+	}
+}
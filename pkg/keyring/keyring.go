@@ -0,0 +1,151 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"sync"
+)
+
+const minSecretSize = 16
+
+var (
+	ErrSecretTooShort = errors.New("keyring: 密钥至少需要 16 个字节")
+	ErrNoKey          = errors.New("keyring: 没有可用的密钥")
+	ErrDecrypt        = errors.New("keyring: 解密失败")
+)
+
+// Keyring 管理一组密钥，第一个是当前使用的主密钥，
+// 签名和加密只使用主密钥，校验和解密会依次尝试所有的密钥，
+// 这样轮换密钥之后，用旧密钥签发的数据在过渡期内依旧有效
+type Keyring struct {
+	mu   sync.RWMutex
+	keys []*key
+}
+
+type key struct {
+	signKey []byte
+	aead    cipher.AEAD
+}
+
+// New 创建 Keyring，secrets 按照从新到旧的顺序排列
+func New(secrets ...[]byte) (*Keyring, error) {
+	res := &Keyring{}
+	for i := len(secrets) - 1; i >= 0; i-- {
+		if err := res.Rotate(secrets[i]); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Rotate 把 secret 设置为新的主密钥，原来的密钥依旧可以用来校验和解密
+func (k *Keyring) Rotate(secret []byte) error {
+	nk, err := newKey(secret)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = append([]*key{nk}, k.keys...)
+	return nil
+}
+
+// Retain 只保留最新的 n 个密钥，用来淘汰过渡期已经结束的旧密钥
+func (k *Keyring) Retain(n int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if n < len(k.keys) {
+		k.keys = k.keys[:n]
+	}
+}
+
+// Sign 用主密钥计算 data 的 HMAC-SHA256
+func (k *Keyring) Sign(data []byte) ([]byte, error) {
+	primary, err := k.primary()
+	if err != nil {
+		return nil, err
+	}
+	return primary.sign(data), nil
+}
+
+// Verify 校验 mac 是否是 data 用任意一个密钥算出来的
+func (k *Keyring) Verify(data, mac []byte) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if hmac.Equal(key.sign(data), mac) {
+			return true
+		}
+	}
+	return false
+}
+
+// Encrypt 用主密钥做 AES-GCM 加密，返回的结果以随机 nonce 开头
+func (k *Keyring) Encrypt(plaintext, additionalData []byte) ([]byte, error) {
+	primary, err := k.primary()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, primary.aead.NonceSize(), primary.aead.NonceSize()+len(plaintext)+primary.aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return primary.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Decrypt 依次尝试用所有的密钥解密
+func (k *Keyring) Decrypt(ciphertext, additionalData []byte) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		size := key.aead.NonceSize()
+		if len(ciphertext) < size {
+			return nil, ErrDecrypt
+		}
+		if res, err := key.aead.Open(nil, ciphertext[:size], ciphertext[size:], additionalData); err == nil {
+			return res, nil
+		}
+	}
+	return nil, ErrDecrypt
+}
+
+func (k *Keyring) primary() (*key, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.keys) == 0 {
+		return nil, ErrNoKey
+	}
+	return k.keys[0], nil
+}
+
+func newKey(secret []byte) (*key, error) {
+	if len(secret) < minSecretSize {
+		return nil, ErrSecretTooShort
+	}
+	// 签名和加密从同一个 secret 派生出不同的密钥，避免一个密钥两用
+	block, err := aes.NewCipher(derive(secret, "encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &key{signKey: derive(secret, "sign"), aead: aead}, nil
+}
+
+func (k *key) sign(data []byte) []byte {
+	h := hmac.New(sha256.New, k.signKey)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func derive(secret []byte, purpose string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte("sepweb-" + purpose))
+	return h.Sum(nil)
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"context"
+	"github.com/igevin/sepweb/pkg/session"
+)
+
+// InstrumentSessionStore 包装 store，统计 Get 的命中和未命中次数
+func InstrumentSessionStore(store session.Store, r *Registry) session.Store {
+	return &sessionStore{
+		Store: store,
+		gets: r.NewCounter("session_store_gets_total",
+			"Total number of session store lookups.", "result"),
+	}
+}
+
+type sessionStore struct {
+	session.Store
+	gets *Counter
+}
+
+func (s *sessionStore) Get(ctx context.Context, id string) (session.Session, error) {
+	sess, err := s.Store.Get(ctx, id)
+	if err != nil {
+		s.gets.Inc("miss")
+	} else {
+		s.gets.Inc("hit")
+	}
+	return sess, err
+}
+
+// StaticCacheObserver 返回的回调可以传给 file.WithCacheObserver，统计静态资源缓存的命中率
+func StaticCacheObserver(r *Registry) func(hit bool) {
+	lookups := r.NewCounter("static_cache_lookups_total",
+		"Total number of static resource cache lookups.", "result")
+	return func(hit bool) {
+		if hit {
+			lookups.Inc("hit")
+			return
+		}
+		lookups.Inc("miss")
+	}
+}
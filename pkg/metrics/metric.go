@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// vec 按照标签的取值保存每一条时间序列
+type vec[T any] struct {
+	d      *desc
+	mu     sync.Mutex
+	series map[string]*seriesOf[T]
+}
+
+type seriesOf[T any] struct {
+	labelValues []string
+	val         T
+}
+
+func newVec[T any](d *desc) *vec[T] {
+	return &vec[T]{d: d, series: make(map[string]*seriesOf[T])}
+}
+
+func (v *vec[T]) desc() *desc {
+	return v.d
+}
+
+// with 在持有锁的情况下找到标签对应的序列并交给 fn 修改
+func (v *vec[T]) with(labelValues []string, fn func(val *T)) {
+	if len(labelValues) != len(v.d.labels) {
+		panic(fmt.Sprintf("metrics: 指标 %s 需要 %d 个标签，实际传了 %d 个",
+			v.d.name, len(v.d.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &seriesOf[T]{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	fn(&s.val)
+}
+
+// each 按照标签排好序之后遍历所有的序列，保证输出是稳定的
+func (v *vec[T]) each(fn func(labelValues []string, val T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	type item struct {
+		labelValues []string
+		val         T
+	}
+	items := make([]item, 0, len(keys))
+	for _, k := range keys {
+		s := v.series[k]
+		items = append(items, item{labelValues: s.labelValues, val: s.val})
+	}
+	v.mu.Unlock()
+	for _, it := range items {
+		fn(it.labelValues, it.val)
+	}
+}
+
+func (v *vec[T]) labelString(labelValues []string, extraName, extraValue string) string {
+	if len(labelValues) == 0 && extraName == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(labelValues)+1)
+	for i, name := range v.d.labels {
+		pairs = append(pairs, name+`="`+escapeLabelValue(labelValues[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter 是只增不减的计数器
+type Counter struct {
+	*vec[float64]
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add 增加计数，v 不能是负数
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter 不能减少")
+	}
+	c.with(labelValues, func(val *float64) {
+		*val += v
+	})
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.each(func(labelValues []string, val float64) {
+		fmt.Fprintf(w, "%s%s %s\n", c.d.name, c.labelString(labelValues, "", ""), formatFloat(val))
+	})
+}
+
+// Gauge 是可以任意增减的仪表盘
+type Gauge struct {
+	*vec[float64]
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.with(labelValues, func(val *float64) {
+		*val = v
+	})
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.with(labelValues, func(val *float64) {
+		*val += v
+	})
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.each(func(labelValues []string, val float64) {
+		fmt.Fprintf(w, "%s%s %s\n", g.d.name, g.labelString(labelValues, "", ""), formatFloat(val))
+	})
+}
+
+// Histogram 统计观测值的分布
+type Histogram struct {
+	*vec[histogramValue]
+	buckets []float64
+}
+
+type histogramValue struct {
+	// counts[i] 是落在 (buckets[i-1], buckets[i]] 的观测值个数，最后一个是 +Inf
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.with(labelValues, func(val *histogramValue) {
+		if val.counts == nil {
+			val.counts = make([]uint64, len(h.buckets)+1)
+		}
+		val.counts[sort.SearchFloat64s(h.buckets, v)]++
+		val.sum += v
+		val.count++
+	})
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.each(func(labelValues []string, val histogramValue) {
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += val.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.d.name,
+				h.labelString(labelValues, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.d.name, h.labelString(labelValues, "le", "+Inf"), val.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.d.name, h.labelString(labelValues, "", ""), formatFloat(val.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.d.name, h.labelString(labelValues, "", ""), val.count)
+	})
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets 是响应时间直方图默认的分桶，单位是秒
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry 管理所有的指标，并按照 Prometheus 的文本格式输出
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+	// order 保证输出的顺序和注册的顺序一致
+	order []string
+}
+
+type metric interface {
+	desc() *desc
+	write(w *bufio.Writer)
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// NewCounter 注册一个计数器。同名同类型的指标已经存在的时候直接返回它
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	d := &desc{name: name, help: help, typ: "counter", labels: labels}
+	return r.register(d, func() metric {
+		return &Counter{vec: newVec[float64](d)}
+	}).(*Counter)
+}
+
+// NewGauge 注册一个仪表盘。同名同类型的指标已经存在的时候直接返回它
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	d := &desc{name: name, help: help, typ: "gauge", labels: labels}
+	return r.register(d, func() metric {
+		return &Gauge{vec: newVec[float64](d)}
+	}).(*Gauge)
+}
+
+// NewHistogram 注册一个直方图，buckets 为空的时候使用 DefaultBuckets。
+// 同名同类型的指标已经存在的时候直接返回它
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	d := &desc{name: name, help: help, typ: "histogram", labels: labels}
+	return r.register(d, func() metric {
+		return &Histogram{vec: newVec[histogramValue](d), buckets: buckets}
+	}).(*Histogram)
+}
+
+func (r *Registry) register(d *desc, create func() metric) metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.metrics[d.name]; ok {
+		old := m.desc()
+		if old.typ != d.typ || strings.Join(old.labels, ",") != strings.Join(d.labels, ",") {
+			panic(fmt.Sprintf("metrics: 指标 %s 已经以不同的类型或者标签注册过了", d.name))
+		}
+		return m
+	}
+	m := create()
+	r.metrics[d.name] = m
+	r.order = append(r.order, d.name)
+	return m
+}
+
+// WriteTo 按照 Prometheus 的文本格式输出所有的指标
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := make([]metric, 0, len(r.order))
+	for _, name := range r.order {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		d := m.desc()
+		fmt.Fprintf(bw, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", d.name, d.typ)
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler 返回输出指标的 handler，可以挂到任意路由上，比如 /metrics
+func (r *Registry) Handler() handler.Handle {
+	return func(ctx *context.Context) {
+		sb := &strings.Builder{}
+		if _, err := r.WriteTo(sb); err != nil {
+			ctx.RespStatusCode = http.StatusInternalServerError
+			return
+		}
+		ctx.Blob(http.StatusOK, contentType, []byte(sb.String()))
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
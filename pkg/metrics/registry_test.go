@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("jobs_total", "Total jobs.", "queue")
+	c.Inc("mail")
+	c.Add(2, `a"b`)
+	g := r.NewGauge("workers", "Busy\nworkers.")
+	g.Set(3)
+	g.Dec()
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	// 同名同类型直接返回已有的指标
+	assert.Same(t, c, r.NewCounter("jobs_total", "Total jobs.", "queue"))
+	assert.Panics(t, func() {
+		r.NewGauge("jobs_total", "Total jobs.", "queue")
+	})
+	assert.Panics(t, func() {
+		c.Inc()
+	})
+
+	sb := &strings.Builder{}
+	_, err := r.WriteTo(sb)
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP jobs_total Total jobs.
+# TYPE jobs_total counter
+jobs_total{queue="a\"b"} 2
+jobs_total{queue="mail"} 1
+# HELP workers Busy\nworkers.
+# TYPE workers gauge
+workers 2
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+`, sb.String())
+}
@@ -0,0 +1,87 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry 是一条访问日志
+type Entry struct {
+	Time       time.Time         `json:"time"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Route      string            `json:"route,omitempty"`
+	PathParams map[string]string `json:"path_params,omitempty"`
+	Proto      string            `json:"proto"`
+	Status     int               `json:"status"`
+	Bytes      int64             `json:"bytes"`
+	Latency    time.Duration     `json:"latency"`
+	RequestID  string            `json:"request_id,omitempty"`
+	ClientIP   string            `json:"client_ip"`
+	UserAgent  string            `json:"user_agent,omitempty"`
+	Referer    string            `json:"referer,omitempty"`
+	// Error 是 Context.AbortWithError 记录的中断原因
+	Error string `json:"error,omitempty"`
+}
+
+// Logger 负责把访问日志输出到某个地方，可以很方便地接到任何日志库上
+type Logger interface {
+	Log(entry *Entry)
+}
+
+type LoggerFunc func(entry *Entry)
+
+func (f LoggerFunc) Log(entry *Entry) {
+	f(entry)
+}
+
+type jsonLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONLogger 每条日志输出一行 JSON
+func NewJSONLogger(w io.Writer) Logger {
+	return &jsonLogger{enc: json.NewEncoder(w)}
+}
+
+func (l *jsonLogger) Log(entry *Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_ = l.enc.Encode(entry)
+}
+
+type combinedLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewCombinedLogger 按照 Apache combined 格式输出日志
+func NewCombinedLogger(w io.Writer) Logger {
+	return &combinedLogger{w: w}
+}
+
+func (l *combinedLogger) Log(entry *Entry) {
+	size := "-"
+	if entry.Bytes > 0 {
+		size = fmt.Sprintf("%d", entry.Bytes)
+	}
+	line := fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		entry.ClientIP, entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.Method, entry.Path, entry.Proto, entry.Status, size,
+		orDash(entry.Referer), orDash(entry.UserAgent))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.w, line)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
@@ -0,0 +1,173 @@
+package accesslog
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"github.com/igevin/sepweb/pkg/middleware/requestid"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+type MiddlewareBuilder struct {
+	logger     Logger
+	sampleRate float64
+	skipRoutes map[string]struct{}
+	skipFunc   func(ctx *context.Context) bool
+}
+
+func NewMiddlewareBuilder(logger Logger) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		logger:     logger,
+		sampleRate: 1,
+		skipRoutes: make(map[string]struct{}),
+	}
+}
+
+// SampleRate 设置采样率，取值范围 [0, 1]。5xx 的请求不受采样率影响，总是会被记录
+func (m *MiddlewareBuilder) SampleRate(rate float64) *MiddlewareBuilder {
+	m.sampleRate = rate
+	return m
+}
+
+// Skip 不记录这些路由的日志，比如健康检查。匹配的是注册路由时用的路径，比如 /users/:id
+func (m *MiddlewareBuilder) Skip(routes ...string) *MiddlewareBuilder {
+	for _, r := range routes {
+		m.skipRoutes[r] = struct{}{}
+	}
+	return m
+}
+
+// SkipFunc 返回 true 的请求不会被记录
+func (m *MiddlewareBuilder) SkipFunc(fn func(ctx *context.Context) bool) *MiddlewareBuilder {
+	m.skipFunc = fn
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			start := time.Now()
+			// 记录直接写到 Resp 上的响应，比如流式响应。rw 不会被换回去，
+			// 里面的中间件可能在它外面又包了一层，要留给 flushResp 使用，比如压缩
+			rw := &responseRecorder{ResponseWriter: ctx.Resp}
+			ctx.Resp = rw
+			// panic 的请求最需要记录，记录之后再交给外层的 recovery 处理
+			defer func() {
+				if r := recover(); r != nil {
+					m.log(ctx, m.newPanicEntry(ctx, rw, start, r))
+					panic(r)
+				}
+			}()
+			next(ctx)
+			m.log(ctx, m.newEntry(ctx, rw, start))
+		}
+	}
+}
+
+func (m *MiddlewareBuilder) log(ctx *context.Context, entry *Entry) {
+	if m.skip(ctx, entry) {
+		return
+	}
+	m.logger.Log(entry)
+}
+
+func (m *MiddlewareBuilder) skip(ctx *context.Context, entry *Entry) bool {
+	if _, ok := m.skipRoutes[ctx.MatchedRoute]; ok {
+		return true
+	}
+	if m.skipFunc != nil && m.skipFunc(ctx) {
+		return true
+	}
+	if entry.Status >= http.StatusInternalServerError {
+		return false
+	}
+	return m.sampleRate < 1 && rand.Float64() >= m.sampleRate
+}
+
+func (m *MiddlewareBuilder) newEntry(ctx *context.Context, rw *responseRecorder, start time.Time) *Entry {
+	entry := m.baseEntry(ctx, rw, start)
+	if err := ctx.AbortError(); err != nil {
+		entry.Error = err.Error()
+	}
+	// 缓冲的响应要等到 flushResp 才会写回，这里根据 RespData 来算
+	if !ctx.Streamed() && !ctx.Hijacked() {
+		entry.Bytes += int64(len(ctx.RespData))
+		if entry.Status == 0 {
+			entry.Status = ctx.RespStatusCode
+		}
+	}
+	if entry.Status == 0 {
+		entry.Status = http.StatusOK
+	}
+	return entry
+}
+
+// baseEntry 填充请求相关的字段，以及已经直接写回的响应码和字节数
+func (m *MiddlewareBuilder) baseEntry(ctx *context.Context, rw *responseRecorder, start time.Time) *Entry {
+	entry := &Entry{
+		Time:       start,
+		Method:     ctx.Req.Method,
+		Path:       ctx.Req.URL.RequestURI(),
+		Route:      ctx.MatchedRoute,
+		PathParams: ctx.PathParams,
+		Proto:      ctx.Req.Proto,
+		Latency:    time.Since(start),
+		ClientIP:   ctx.ClientIP(),
+		UserAgent:  ctx.Req.UserAgent(),
+		Referer:    ctx.Req.Referer(),
+		Bytes:      rw.bytes,
+		Status:     rw.status,
+	}
+	entry.RequestID, _ = requestid.FromContext(ctx)
+	return entry
+}
+
+// newPanicEntry 记录 panic 的请求。还没有写回响应的话，外层的 recovery 会返回 500
+func (m *MiddlewareBuilder) newPanicEntry(ctx *context.Context, rw *responseRecorder, start time.Time, r any) *Entry {
+	entry := m.baseEntry(ctx, rw, start)
+	entry.Error = fmt.Sprint("panic: ", r)
+	if entry.Status == 0 {
+		entry.Status = http.StatusInternalServerError
+	}
+	return entry
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := r.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
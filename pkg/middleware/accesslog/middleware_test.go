@@ -0,0 +1,168 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/middleware/recovery"
+	"github.com/igevin/sepweb/pkg/middleware/requestid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func newServer(builder *MiddlewareBuilder) *sepweb.HttpServer {
+	s := sepweb.NewHttpServer(sepweb.ServerWithTrustedProxies("10.0.0.0/8"))
+	s.Use(recovery.NewMiddlewareBuilder().Build(), requestid.NewMiddlewareBuilder().Build(), builder.Build())
+	s.Get("/users/:id", func(ctx *context.Context) {
+		ctx.RespData = []byte("hello")
+	})
+	s.Get("/export", func(ctx *context.Context) {
+		_ = ctx.Stream("text/plain", func(w io.Writer) error {
+			_, err := w.Write([]byte("streamed"))
+			return err
+		})
+	})
+	s.Get("/health", func(ctx *context.Context) {})
+	s.Get("/boom", func(ctx *context.Context) {
+		ctx.RespStatusCode = http.StatusInternalServerError
+	})
+	s.Get("/admin", func(ctx *context.Context) {
+		ctx.AbortWithError(http.StatusForbidden, errors.New("not admin"))
+	})
+	s.Get("/panic", func(ctx *context.Context) {
+		ctx.RespData = []byte("partial")
+		panic("boom")
+	})
+	return s
+}
+
+func TestMiddlewareBuilder_JSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := newServer(NewMiddlewareBuilder(NewJSONLogger(buf)).Skip("/health"))
+
+	testCases := []struct {
+		name      string
+		path      string
+		wantEntry *Entry
+	}{
+		{
+			name: "buffered",
+			path: "/users/123",
+			wantEntry: &Entry{
+				Method:     http.MethodGet,
+				Path:       "/users/123",
+				Route:      "/users/:id",
+				PathParams: map[string]string{"id": "123"},
+				Proto:      "HTTP/1.1",
+				Status:     http.StatusOK,
+				Bytes:      5,
+				RequestID:  "req-1",
+				ClientIP:   "203.0.113.1",
+				UserAgent:  "test-agent",
+			},
+		},
+		{
+			name: "streamed",
+			path: "/export",
+			wantEntry: &Entry{
+				Method:    http.MethodGet,
+				Path:      "/export",
+				Route:     "/export",
+				Proto:     "HTTP/1.1",
+				Status:    http.StatusOK,
+				Bytes:     8,
+				RequestID: "req-1",
+				ClientIP:  "203.0.113.1",
+				UserAgent: "test-agent",
+			},
+		},
+		{
+			name: "aborted",
+			path: "/admin",
+			wantEntry: &Entry{
+				Method:    http.MethodGet,
+				Path:      "/admin",
+				Route:     "/admin",
+				Proto:     "HTTP/1.1",
+				Status:    http.StatusForbidden,
+				RequestID: "req-1",
+				ClientIP:  "203.0.113.1",
+				UserAgent: "test-agent",
+				Error:     "not admin",
+			},
+		},
+		{
+			name: "panic",
+			path: "/panic",
+			wantEntry: &Entry{
+				Method:    http.MethodGet,
+				Path:      "/panic",
+				Route:     "/panic",
+				Proto:     "HTTP/1.1",
+				Status:    http.StatusInternalServerError,
+				RequestID: "req-1",
+				ClientIP:  "203.0.113.1",
+				UserAgent: "test-agent",
+				Error:     "panic: boom",
+			},
+		},
+		{
+			name: "skipped",
+			path: "/health",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("X-Forwarded-For", "203.0.113.1, 10.0.0.2")
+			req.Header.Set("User-Agent", "test-agent")
+			req.Header.Set(requestid.DefaultHeader, "req-1")
+			s.ServeHTTP(httptest.NewRecorder(), req)
+			if tc.wantEntry == nil {
+				assert.Equal(t, 0, buf.Len())
+				return
+			}
+			entry := &Entry{}
+			require.NoError(t, json.Unmarshal(buf.Bytes(), entry))
+			assert.False(t, entry.Time.IsZero())
+			entry.Time = tc.wantEntry.Time
+			entry.Latency = 0
+			assert.Equal(t, tc.wantEntry, entry)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Combined(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := newServer(NewMiddlewareBuilder(NewCombinedLogger(buf)))
+	req := httptest.NewRequest(http.MethodGet, "/users/123?tab=1", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	// 不是可信代理，X-Forwarded-For 会被忽略
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	req.Header.Set("User-Agent", "test-agent")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Regexp(t, regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "GET /users/123\?tab=1 HTTP/1\.1" 200 5 "-" "test-agent"\n$`),
+		buf.String())
+}
+
+func TestMiddlewareBuilder_Sample(t *testing.T) {
+	var entries []*Entry
+	s := newServer(NewMiddlewareBuilder(LoggerFunc(func(entry *Entry) {
+		entries = append(entries, entry)
+	})).SampleRate(0))
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/123", nil))
+	assert.Len(t, entries, 0)
+	// 5xx 总是会被记录
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/boom", nil))
+	require.Len(t, entries, 1)
+	assert.Equal(t, http.StatusInternalServerError, entries[0].Status)
+}
@@ -0,0 +1,26 @@
+package bodylimit
+
+import (
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+)
+
+// MiddlewareBuilder 限制请求体的大小，一般作为路由级别的中间件使用。
+// 它只能比服务器级别的限制更严格，不能放宽
+type MiddlewareBuilder struct {
+	maxBytes int64
+}
+
+func NewMiddlewareBuilder(maxBytes int64) *MiddlewareBuilder {
+	return &MiddlewareBuilder{maxBytes: maxBytes}
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			ctx.LimitBody(m.maxBytes)
+			next(ctx)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package cache
+
+import (
+	stdctx "context"
+	"errors"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"github.com/igevin/sepweb/pkg/middleware/secure"
+	"log"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var tagsKey = context.NewKey[*[]string]("cache-tags")
+
+// MiddlewareBuilder 缓存 GET 请求的 200 响应，一般作为路由或者路由组的中间件使用。
+// 带有 Set-Cookie，或者 Cache-Control 为 no-store、private 的响应不会被缓存。
+// 带有 Authorization 的请求不会读取缓存，它的响应只有 Cache-Control 里有 public、
+// s-maxage 或者 must-revalidate 的时候才会被缓存。
+// 缓存的响应体里会带着生成它的那个请求的 CSP nonce，所以 secure 中间件生成了 nonce 的请求不会使用缓存
+type MiddlewareBuilder struct {
+	store       Store
+	ttl         time.Duration
+	queryParams []string
+	varyHeaders []string
+	tags        []string
+	group       group
+}
+
+func NewMiddlewareBuilder(store Store, ttl time.Duration) *MiddlewareBuilder {
+	return &MiddlewareBuilder{store: store, ttl: ttl}
+}
+
+// QueryParams 设置哪些查询参数会影响响应，只有它们会参与计算缓存的 key。
+// 不设置的话，所有查询参数都会参与
+func (m *MiddlewareBuilder) QueryParams(names ...string) *MiddlewareBuilder {
+	m.queryParams = names
+	return m
+}
+
+// VaryHeaders 设置哪些请求头会影响响应，它们会参与计算缓存的 key。
+// 响应的 Vary 里出现了这里没有的请求头的时候，响应不会被缓存
+func (m *MiddlewareBuilder) VaryHeaders(headers ...string) *MiddlewareBuilder {
+	m.varyHeaders = make([]string, 0, len(headers))
+	for _, h := range headers {
+		m.varyHeaders = append(m.varyHeaders, textproto.CanonicalMIMEHeaderKey(h))
+	}
+	sort.Strings(m.varyHeaders)
+	return m
+}
+
+// Tags 给这个中间件缓存的所有响应打上标签，之后可以通过 Store.InvalidateTags 批量删除
+func (m *MiddlewareBuilder) Tags(tags ...string) *MiddlewareBuilder {
+	m.tags = tags
+	return m
+}
+
+// AddTags 在 handler 里给当前的响应追加标签，比如 user:42
+func AddTags(ctx *context.Context, tags ...string) {
+	if p, ok := context.Get(ctx, tagsKey); ok {
+		*p = append(*p, tags...)
+	}
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			method := ctx.Req.Method
+			if method != http.MethodGet && method != http.MethodHead {
+				next(ctx)
+				return
+			}
+			cc := parseCacheControl(ctx.Req.Header.Get("Cache-Control"))
+			if _, ok := cc["no-store"]; ok {
+				next(ctx)
+				return
+			}
+			if secure.Nonce(ctx) != "" {
+				next(ctx)
+				return
+			}
+			key := m.key(ctx)
+			if ctx.Req.Header.Get("Authorization") != "" {
+				// 响应可能只属于这个用户，不能用别人的缓存，也不能让别人等它的结果
+				if method == http.MethodGet {
+					m.fill(ctx, next, key)
+				} else {
+					next(ctx)
+				}
+				return
+			}
+			// no-cache 要求跳过缓存，但是新的响应依旧可以缓存
+			if _, ok := cc["no-cache"]; !ok {
+				if entry := m.lookup(ctx, key, cc); entry != nil {
+					m.writeEntry(ctx, entry)
+					return
+				}
+			}
+			if _, ok := cc["only-if-cached"]; ok {
+				ctx.AbortWithStatus(http.StatusGatewayTimeout)
+				return
+			}
+			// HEAD 请求没有响应体，不能用来填充缓存
+			if method == http.MethodHead {
+				next(ctx)
+				return
+			}
+			entry, leader := m.group.do(key, func() *Entry {
+				return m.fill(ctx, next, key)
+			})
+			if leader {
+				ctx.Resp.Header().Set("X-Cache", "MISS")
+				return
+			}
+			if entry == nil {
+				// 等到的响应不能缓存，只能自己执行
+				next(ctx)
+				return
+			}
+			m.writeEntry(ctx, entry)
+		}
+	}
+}
+
+func (m *MiddlewareBuilder) lookup(ctx *context.Context, key string, cc map[string]string) *Entry {
+	entry, err := m.store.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, ErrCacheMiss) {
+			log.Println("cache: 读取缓存失败", err)
+		}
+		return nil
+	}
+	if val, ok := cc["max-age"]; ok {
+		maxAge, err := strconv.Atoi(val)
+		if err == nil && time.Since(entry.StoredAt) > time.Duration(maxAge)*time.Second {
+			return nil
+		}
+	}
+	return entry
+}
+
+// fill 执行 handler，响应可以缓存的时候保存起来并返回
+func (m *MiddlewareBuilder) fill(ctx *context.Context, next handler.Handle, key string) *Entry {
+	tags := append([]string(nil), m.tags...)
+	context.Set(ctx, tagsKey, &tags)
+	// 外层的中间件可能已经写入了只属于这个请求的头部，比如 X-Request-ID，
+	// 所以只保存 handler 新增或者修改过的头部
+	before := ctx.Resp.Header().Clone()
+	next(ctx)
+	if !m.cacheable(ctx) {
+		return nil
+	}
+	entry := &Entry{
+		Status:   http.StatusOK,
+		Header:   changedHeader(before, ctx.Resp.Header()),
+		Body:     append([]byte(nil), ctx.RespData...),
+		Tags:     tags,
+		StoredAt: time.Now(),
+	}
+	entry.Header.Del("X-Cache")
+	if err := m.store.Set(stdctx.Background(), key, entry, m.ttl); err != nil {
+		log.Println("cache: 写入缓存失败", err)
+	}
+	return entry
+}
+
+// changedHeader 返回 after 里相对 before 新增或者值变了的头部
+func changedHeader(before, after http.Header) http.Header {
+	res := make(http.Header, len(after))
+	for k, v := range after {
+		if old, ok := before[k]; ok && equalValues(old, v) {
+			continue
+		}
+		res[k] = append([]string(nil), v...)
+	}
+	return res
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MiddlewareBuilder) cacheable(ctx *context.Context) bool {
+	if ctx.Streamed() || ctx.Hijacked() || ctx.IsAborted() {
+		return false
+	}
+	if ctx.RespStatusCode != 0 && ctx.RespStatusCode != http.StatusOK {
+		return false
+	}
+	header := ctx.Resp.Header()
+	if header.Get("Set-Cookie") != "" {
+		return false
+	}
+	cc := parseCacheControl(strings.Join(header.Values("Cache-Control"), ","))
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if _, ok := cc["private"]; ok {
+		return false
+	}
+	if ctx.Req.Header.Get("Authorization") != "" && !sharedForAuthorized(cc) {
+		return false
+	}
+	for _, v := range header.Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			h = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(h))
+			if h != "" && !m.varies(h) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sharedForAuthorized 判断带 Authorization 的请求的响应能不能被共享缓存保存，参考 RFC 9111 3.5
+func sharedForAuthorized(cc map[string]string) bool {
+	for _, d := range []string{"public", "s-maxage", "must-revalidate"} {
+		if _, ok := cc[d]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MiddlewareBuilder) varies(header string) bool {
+	for _, h := range m.varyHeaders {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MiddlewareBuilder) writeEntry(ctx *context.Context, entry *Entry) {
+	header := ctx.Resp.Header()
+	for k, v := range entry.Header {
+		// 当前请求已经有的头部以当前请求为准
+		if _, ok := header[k]; ok {
+			continue
+		}
+		header[k] = append([]string(nil), v...)
+	}
+	header.Set("X-Cache", "HIT")
+	header.Set("Age", strconv.Itoa(int(time.Since(entry.StoredAt).Seconds())))
+	ctx.RespStatusCode = entry.Status
+	ctx.RespData = entry.Body
+}
+
+// key 由方法、路径、查询参数和 Vary 的请求头组成。HEAD 和 GET 共用缓存
+func (m *MiddlewareBuilder) key(ctx *context.Context) string {
+	var sb strings.Builder
+	sb.WriteString(http.MethodGet)
+	sb.WriteByte(' ')
+	sb.WriteString(ctx.Req.URL.Path)
+	query := ctx.Req.URL.Query()
+	if m.queryParams != nil {
+		selected := url.Values{}
+		for _, name := range m.queryParams {
+			if vals, ok := query[name]; ok {
+				selected[name] = vals
+			}
+		}
+		query = selected
+	}
+	if len(query) > 0 {
+		// Encode 会按照参数名排序
+		sb.WriteByte('?')
+		sb.WriteString(query.Encode())
+	}
+	for _, h := range m.varyHeaders {
+		sb.WriteString("\n" + h + ": " + strings.Join(ctx.Req.Header.Values(h), ","))
+	}
+	return sb.String()
+}
+
+// parseCacheControl 把 Cache-Control 解析成指令到参数的映射，指令名统一转成小写
+func parseCacheControl(val string) map[string]string {
+	res := make(map[string]string)
+	for _, part := range strings.Split(val, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(part, "=")
+		res[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+	return res
+}
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	stdctx "context"
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware/secure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	store, err := NewLRUStore(16)
+	require.NoError(t, err)
+	var calls int64
+	s := sepweb.NewHttpServer()
+	// 外层中间件设置的只属于当前请求的头部
+	s.Use(func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			ctx.Resp.Header().Set("X-Request-ID", ctx.Req.Header.Get("X-Request-ID"))
+			next(ctx)
+		}
+	})
+	articles := s.Group("/articles", NewMiddlewareBuilder(store, time.Minute).
+		QueryParams("page").VaryHeaders("Accept-Language").Tags("articles").Build())
+	articles.Get("/:id", func(ctx *context.Context) {
+		n := atomic.AddInt64(&calls, 1)
+		AddTags(ctx, "article:"+ctx.PathParams["id"])
+		ctx.Resp.Header().Set("Vary", "Accept-Language")
+		ctx.String(http.StatusOK, ctx.Req.Header.Get("Accept-Language")+"#"+strconv.FormatInt(n, 10))
+	})
+	articles.Get("/:id/comments", func(ctx *context.Context) {
+		n := atomic.AddInt64(&calls, 1)
+		ctx.SetCookie(&http.Cookie{Name: "seen", Value: "1"})
+		ctx.String(http.StatusOK, strconv.FormatInt(n, 10))
+	})
+
+	serve := func(path string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := serve("/articles/1?page=1&utm=a", map[string]string{"X-Request-ID": "aaa"})
+	assert.Equal(t, "#1", recorder.Body.String())
+	assert.Equal(t, "MISS", recorder.Header().Get("X-Cache"))
+	// 没有选中的查询参数不影响缓存
+	recorder = serve("/articles/1?utm=b&page=1", map[string]string{"X-Request-ID": "bbb"})
+	assert.Equal(t, "#1", recorder.Body.String())
+	assert.Equal(t, "HIT", recorder.Header().Get("X-Cache"))
+	assert.Equal(t, "Accept-Language", recorder.Header().Get("Vary"))
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "bbb", recorder.Header().Get("X-Request-ID"))
+	assert.Equal(t, "#2", serve("/articles/1?page=2", nil).Body.String())
+	assert.Equal(t, "zh#3", serve("/articles/1?page=1", map[string]string{"Accept-Language": "zh"}).Body.String())
+	assert.Equal(t, "zh#3", serve("/articles/1?page=1", map[string]string{"Accept-Language": "zh"}).Body.String())
+
+	// Cache-Control 请求指令
+	assert.Equal(t, "#4", serve("/articles/1?page=1", map[string]string{"Cache-Control": "no-cache"}).Body.String())
+	assert.Equal(t, "#4", serve("/articles/1?page=1", nil).Body.String())
+	assert.Equal(t, "#5", serve("/articles/1?page=1", map[string]string{"Cache-Control": "no-store"}).Body.String())
+	assert.Equal(t, "#4", serve("/articles/1?page=1", map[string]string{"Cache-Control": "max-age=60"}).Body.String())
+	assert.Equal(t, http.StatusGatewayTimeout,
+		serve("/articles/9", map[string]string{"Cache-Control": "only-if-cached"}).Code)
+
+	// 带 Set-Cookie 的响应不缓存
+	assert.Equal(t, "6", serve("/articles/1/comments", nil).Body.String())
+	assert.Equal(t, "7", serve("/articles/1/comments", nil).Body.String())
+
+	// 按照标签失效
+	assert.Equal(t, "#8", serve("/articles/2", nil).Body.String())
+	require.NoError(t, store.InvalidateTags(stdctx.Background(), "article:1"))
+	assert.Equal(t, "#9", serve("/articles/1?page=1", nil).Body.String())
+	assert.Equal(t, "#8", serve("/articles/2", nil).Body.String())
+	require.NoError(t, store.InvalidateTags(stdctx.Background(), "articles"))
+	assert.Equal(t, "#10", serve("/articles/2", nil).Body.String())
+}
+
+func TestMiddlewareBuilder_Singleflight(t *testing.T) {
+	store, err := NewLRUStore(16)
+	require.NoError(t, err)
+	var calls int64
+	release := make(chan struct{})
+	s := sepweb.NewHttpServer()
+	s.Get("/slow", func(ctx *context.Context) {
+		atomic.AddInt64(&calls, 1)
+		<-release
+		ctx.String(http.StatusOK, "slow")
+	}, NewMiddlewareBuilder(store, time.Minute).Build())
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+			bodies[i] = recorder.Body.String()
+		}(i)
+	}
+	// 等其它请求都排上队再放行
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&calls))
+	for _, body := range bodies {
+		assert.Equal(t, "slow", body)
+	}
+}
+
+func TestMiddlewareBuilder_Authorization(t *testing.T) {
+	store, err := NewLRUStore(16)
+	require.NoError(t, err)
+	s := sepweb.NewHttpServer()
+	mdl := NewMiddlewareBuilder(store, time.Minute).Build()
+	s.Get("/me", func(ctx *context.Context) {
+		ctx.String(http.StatusOK, ctx.Req.Header.Get("Authorization"))
+	}, mdl)
+	s.Get("/notice", func(ctx *context.Context) {
+		ctx.Resp.Header().Set("Cache-Control", "public, max-age=60")
+		ctx.String(http.StatusOK, ctx.Req.Header.Get("Authorization"))
+	}, mdl)
+
+	serve := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	assert.Equal(t, "Bearer alice", serve("/me", "alice").Body.String())
+	assert.Equal(t, "Bearer bob", serve("/me", "bob").Body.String())
+	assert.Equal(t, "", serve("/me", "").Body.String())
+	// 匿名请求的缓存也不能给带认证的请求用
+	assert.Equal(t, "Bearer bob", serve("/me", "bob").Body.String())
+
+	// public 的响应可以共享
+	assert.Equal(t, "Bearer alice", serve("/notice", "alice").Body.String())
+	recorder := serve("/notice", "")
+	assert.Equal(t, "Bearer alice", recorder.Body.String())
+	assert.Equal(t, "HIT", recorder.Header().Get("X-Cache"))
+}
+
+func TestMiddlewareBuilder_Nonce(t *testing.T) {
+	store, err := NewLRUStore(16)
+	require.NoError(t, err)
+	var calls int64
+	s := sepweb.NewHttpServer()
+	s.Use(secure.NewMiddlewareBuilder().ContentSecurityPolicy("script-src " + secure.NoncePlaceholder).Build())
+	s.Get("/page", func(ctx *context.Context) {
+		atomic.AddInt64(&calls, 1)
+		ctx.String(http.StatusOK, secure.Nonce(ctx))
+	}, NewMiddlewareBuilder(store, time.Minute).Build())
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/page", nil))
+		assert.Contains(t, recorder.Header().Get("Content-Security-Policy"), "'nonce-"+recorder.Body.String()+"'")
+	}
+	assert.Equal(t, int64(2), atomic.LoadInt64(&calls))
+}
+
+func TestLRUStore(t *testing.T) {
+	store, err := NewLRUStore(2)
+	require.NoError(t, err)
+	now := time.Now()
+	store.now = func() time.Time {
+		return now
+	}
+	ctx := stdctx.Background()
+	require.NoError(t, store.Set(ctx, "a", &Entry{Tags: []string{"t"}}, time.Minute))
+	require.NoError(t, store.Set(ctx, "b", &Entry{Tags: []string{"t"}}, time.Second))
+	require.NoError(t, store.Set(ctx, "c", &Entry{}, time.Minute))
+	// a 被淘汰，标签索引里也不应该再有它
+	_, err = store.Get(ctx, "a")
+	assert.Equal(t, ErrCacheMiss, err)
+	assert.Equal(t, map[string]struct{}{"b": {}}, store.tags["t"])
+
+	now = now.Add(2 * time.Second)
+	_, err = store.Get(ctx, "b")
+	assert.Equal(t, ErrCacheMiss, err)
+	assert.Empty(t, store.tags)
+	_, err = store.Get(ctx, "c")
+	assert.NoError(t, err)
+}
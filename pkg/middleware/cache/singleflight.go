@@ -0,0 +1,42 @@
+package cache
+
+import "sync"
+
+// group 合并同一时间对同一个 key 的计算，避免缓存失效的时候大量请求同时打到 handler 上
+type group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	wg    sync.WaitGroup
+	entry *Entry
+}
+
+// do 执行 fn 并返回结果。同一个 key 已经有调用在执行的时候等待它的结果，
+// leader 表示 fn 是不是在当前的 goroutine 里执行的
+func (g *group) do(key string, fn func() *Entry) (entry *Entry, leader bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.entry, false
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	// fn panic 的时候也要唤醒等待的请求，它们拿到 nil 之后会自己执行
+	defer func() {
+		c.wg.Done()
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+	}()
+	c.entry = fn()
+	return c.entry, true
+}
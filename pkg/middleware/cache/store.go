@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	lru "github.com/hashicorp/golang-lru"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrCacheMiss = errors.New("cache: 缓存不存在")
+
+// Entry 是缓存的一个响应
+type Entry struct {
+	Status   int
+	Header   http.Header
+	Body     []byte
+	Tags     []string
+	StoredAt time.Time
+}
+
+// Store 保存缓存的响应，外部的存储（比如 Redis）实现它就可以在多个实例之间共享缓存
+type Store interface {
+	// Get 取出 key 对应的响应，不存在或者已经过期的时候返回 ErrCacheMiss
+	Get(ctx context.Context, key string) (*Entry, error)
+	Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// InvalidateTags 删除带有任意一个标签的响应
+	InvalidateTags(ctx context.Context, tags ...string) error
+}
+
+// LRUStore 是进程内的 Store，超过容量的时候淘汰最久没有使用的响应
+type LRUStore struct {
+	mu    sync.Mutex
+	cache *lru.Cache
+	// tags 是标签到 key 的索引
+	tags map[string]map[string]struct{}
+	now  func() time.Time
+}
+
+type lruItem struct {
+	entry    *Entry
+	expireAt time.Time
+}
+
+func NewLRUStore(size int) (*LRUStore, error) {
+	s := &LRUStore{
+		tags: make(map[string]map[string]struct{}),
+		now:  time.Now,
+	}
+	c, err := lru.NewWithEvict(size, s.onEvict)
+	if err != nil {
+		return nil, err
+	}
+	s.cache = c
+	return s, nil
+}
+
+func (s *LRUStore) Get(ctx context.Context, key string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, ok := s.cache.Get(key)
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	item := val.(*lruItem)
+	if !s.now().Before(item.expireAt) {
+		s.cache.Remove(key)
+		return nil, ErrCacheMiss
+	}
+	return item.entry, nil
+}
+
+func (s *LRUStore) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 先删掉旧的，让标签索引保持一致
+	s.cache.Remove(key)
+	s.cache.Add(key, &lruItem{entry: entry, expireAt: s.now().Add(ttl)})
+	for _, tag := range entry.Tags {
+		keys, ok := s.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			s.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	return nil
+}
+
+func (s *LRUStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache.Remove(key)
+	return nil
+}
+
+func (s *LRUStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tag := range tags {
+		for key := range s.tags[tag] {
+			s.cache.Remove(key)
+		}
+	}
+	return nil
+}
+
+func (s *LRUStore) Len() int {
+	return s.cache.Len()
+}
+
+// onEvict 在持有 s.mu 的时候被 lru 回调，把 key 从标签索引里移除
+func (s *LRUStore) onEvict(key any, val any) {
+	k := key.(string)
+	for _, tag := range val.(*lruItem).entry.Tags {
+		if keys, ok := s.tags[tag]; ok {
+			delete(keys, k)
+			if len(keys) == 0 {
+				delete(s.tags, tag)
+			}
+		}
+	}
+}
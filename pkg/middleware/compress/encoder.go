@@ -0,0 +1,62 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"sync"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// encoder 是可以复用的压缩器。创建 gzip 和 zlib 的 writer 开销很大，所以放到池子里
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoderPool 按照编码缓存 encoder，同一个中间件的压缩级别是固定的
+type encoderPool struct {
+	gzip    sync.Pool
+	deflate sync.Pool
+}
+
+func newEncoderPool(level int) *encoderPool {
+	p := &encoderPool{}
+	p.gzip.New = func() any {
+		// 只有 level 不合法的时候才会出错，Build 的时候已经检查过了
+		w, _ := gzip.NewWriterLevel(io.Discard, level)
+		return w
+	}
+	// HTTP 里的 deflate 是带 zlib 头的格式，不是裸的 DEFLATE 数据
+	p.deflate.New = func() any {
+		w, _ := zlib.NewWriterLevel(io.Discard, level)
+		return w
+	}
+	return p
+}
+
+func (p *encoderPool) get(encoding string, w io.Writer) encoder {
+	var enc encoder
+	if encoding == encodingGzip {
+		enc = p.gzip.Get().(*gzip.Writer)
+	} else {
+		enc = p.deflate.Get().(*zlib.Writer)
+	}
+	enc.Reset(w)
+	return enc
+}
+
+func (p *encoderPool) put(encoding string, enc encoder) {
+	// 避免池子里的 encoder 继续引用已经结束的响应
+	enc.Reset(io.Discard)
+	if encoding == encodingGzip {
+		p.gzip.Put(enc)
+	} else {
+		p.deflate.Put(enc)
+	}
+}
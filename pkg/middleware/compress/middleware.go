@@ -0,0 +1,304 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultMinSize 是默认的压缩阈值，太小的响应压缩之后反而可能更大
+const DefaultMinSize = 1024
+
+// DefaultContentTypes 是默认会被压缩的内容类型，以 / 结尾的表示匹配这个大类下所有的类型
+var DefaultContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return &bytes.Buffer{}
+	},
+}
+
+type MiddlewareBuilder struct {
+	level        int
+	minSize      int
+	contentTypes []string
+}
+
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		level:        flate.DefaultCompression,
+		minSize:      DefaultMinSize,
+		contentTypes: DefaultContentTypes,
+	}
+}
+
+// Level 设置压缩级别，取值和 compress/flate 一样
+func (m *MiddlewareBuilder) Level(level int) *MiddlewareBuilder {
+	m.level = level
+	return m
+}
+
+// MinSize 设置压缩阈值，小于它的响应不压缩。流式响应不知道大小，只要内容类型合适就会压缩
+func (m *MiddlewareBuilder) MinSize(size int) *MiddlewareBuilder {
+	m.minSize = size
+	return m
+}
+
+// ContentTypes 设置会被压缩的内容类型。
+// 除此之外，+json 和 +xml 结尾的类型，比如 application/problem+json，也总是会被压缩。
+// text/event-stream 总是不压缩，压缩器的缓冲会推迟事件到达客户端
+func (m *MiddlewareBuilder) ContentTypes(types ...string) *MiddlewareBuilder {
+	m.contentTypes = types
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	if m.level < flate.HuffmanOnly || m.level > flate.BestCompression {
+		panic("compress: 不合法的压缩级别 " + strconv.Itoa(m.level))
+	}
+	pool := newEncoderPool(m.level)
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			header := ctx.Resp.Header()
+			middleware.AddVary(header, "Accept-Encoding")
+			encoding := negotiate(ctx.Req.Header.Get("Accept-Encoding"))
+			if encoding == "" || ctx.Req.Method == http.MethodHead {
+				next(ctx)
+				return
+			}
+			// 直接写回的响应，比如 Stream 和 SSE，经过 cw 边写边压缩
+			cw := &compressWriter{ResponseWriter: ctx.Resp, m: m, pool: pool, encoding: encoding}
+			ctx.Resp = cw
+			// handler panic 的时候也要结束压缩流，把 encoder 放回池子
+			defer func() {
+				if cw.wroteHeader {
+					cw.close()
+					return
+				}
+				// RespData 在外层的中间件里还可能被替换，比如 errhdl，
+				// 所以 cw 留在 ctx.Resp 上，等 flushResp 真正写回的时候再压缩
+				cw.final = true
+			}()
+			next(ctx)
+		}
+	}
+}
+
+func (m *MiddlewareBuilder) compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mt == "text/event-stream" {
+		return false
+	}
+	if strings.HasSuffix(mt, "+json") || strings.HasSuffix(mt, "+xml") {
+		return true
+	}
+	for _, t := range m.contentTypes {
+		if mt == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mt, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter 在第一次写响应体的时候决定要不要压缩。
+// handler 执行期间的写入一般是流式响应，边写边压缩；
+// final 之后的写入来自 flushResp，是完整的 RespData，一次性压缩好再写回
+type compressWriter struct {
+	http.ResponseWriter
+	m        *MiddlewareBuilder
+	pool     *encoderPool
+	encoding string
+
+	// code 是 WriteHeader 传进来的状态码，真正写回要等到决定了要不要压缩之后
+	code        int
+	wroteHeader bool
+	final       bool
+	enc         encoder
+	// closed 表示压缩流已经结束，之后再写的数据没法拼到压缩流后面
+	closed bool
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.code == 0 {
+		c.code = code
+	}
+}
+
+func (c *compressWriter) status() int {
+	if c.code == 0 {
+		return http.StatusOK
+	}
+	return c.code
+}
+
+// writeHeader 决定要不要压缩并写回响应头，size 小于 0 表示不知道响应体的大小
+func (c *compressWriter) writeHeader(size int) {
+	c.wroteHeader = true
+	code := c.status()
+	if c.shouldCompress(code, size) {
+		header := c.Header()
+		header.Set("Content-Encoding", c.encoding)
+		header.Del("Content-Length")
+		weakenETag(header)
+		c.enc = c.pool.get(c.encoding, c.ResponseWriter)
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *compressWriter) shouldCompress(code int, size int) bool {
+	header := c.Header()
+	if !bodyAllowed(code) || header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if cl, err := strconv.Atoi(header.Get("Content-Length")); err == nil && size < 0 {
+		size = cl
+	}
+	if size >= 0 && size < c.m.minSize {
+		return false
+	}
+	return c.m.compressible(header.Get("Content-Type"))
+}
+
+func (c *compressWriter) Write(data []byte) (int, error) {
+	if !c.wroteHeader {
+		if c.Header().Get("Content-Type") == "" && len(data) > 0 {
+			c.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		if c.final {
+			return c.writeFinal(data)
+		}
+		c.writeHeader(-1)
+	}
+	if c.enc != nil {
+		return c.enc.Write(data)
+	}
+	if c.closed {
+		if len(data) == 0 {
+			return 0, nil
+		}
+		return 0, http.ErrBodyNotAllowed
+	}
+	return c.ResponseWriter.Write(data)
+}
+
+// writeFinal 压缩完整的响应体，这时候知道压缩之后的大小，可以设置 Content-Length
+func (c *compressWriter) writeFinal(data []byte) (int, error) {
+	c.wroteHeader = true
+	code := c.status()
+	if !c.shouldCompress(code, len(data)) {
+		c.ResponseWriter.WriteHeader(code)
+		return c.ResponseWriter.Write(data)
+	}
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+	enc := c.pool.get(c.encoding, buf)
+	_, err := enc.Write(data)
+	if err == nil {
+		err = enc.Close()
+	}
+	c.pool.put(c.encoding, enc)
+	if err != nil {
+		c.ResponseWriter.WriteHeader(code)
+		return c.ResponseWriter.Write(data)
+	}
+	header := c.Header()
+	header.Set("Content-Encoding", c.encoding)
+	header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	weakenETag(header)
+	c.ResponseWriter.WriteHeader(code)
+	if _, err = c.ResponseWriter.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Flush 先把压缩器里缓冲的数据推出去，流式响应才能及时到达客户端
+func (c *compressWriter) Flush() {
+	if !c.wroteHeader {
+		c.writeHeader(-1)
+	}
+	if c.enc != nil {
+		_ = c.enc.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := c.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+func (c *compressWriter) close() {
+	if c.enc == nil {
+		return
+	}
+	_ = c.enc.Close()
+	c.pool.put(c.encoding, c.enc)
+	c.enc = nil
+	c.closed = true
+}
+
+// weakenETag 把强 ETag 换成弱 ETag。压缩之后的字节和原始的表示不一样，
+// 同一个强 ETag 不能同时用在两种表示上，弱比较依旧可以匹配原来的 ETag
+func weakenETag(header http.Header) {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+}
+
+func bodyAllowed(code int) bool {
+	return code != http.StatusNoContent && code != http.StatusNotModified &&
+		(code >= http.StatusOK || code == 0)
+}
+
+// negotiate 根据 Accept-Encoding 选择编码，q 值相同的时候优先 gzip
+func negotiate(accept string) string {
+	best, bestQ := "", 0.0
+	wildcardQ := -1.0
+	qs := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if v, err := strconv.ParseFloat(params[2:], 64); err == nil {
+				q = v
+			}
+		}
+		if name == "*" {
+			wildcardQ = q
+			continue
+		}
+		qs[name] = q
+	}
+	for _, enc := range []string{encodingGzip, encodingDeflate} {
+		q, ok := qs[enc]
+		if !ok {
+			q = wildcardQ
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,155 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware/accesslog"
+	"github.com/igevin/sepweb/pkg/middleware/errhdl"
+	"github.com/igevin/sepweb/pkg/middleware/recovery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	large := strings.Repeat("hello world ", 200)
+	s := sepweb.NewHttpServer()
+	// accesslog 在最外层，它包装的 Resp 不能把压缩的 Resp 换掉
+	s.Use(accesslog.NewMiddlewareBuilder(accesslog.LoggerFunc(func(entry *accesslog.Entry) {})).Build())
+	// errhdl 在外层，压缩之后它还会替换 RespData
+	s.Use(errhdl.NewMiddlewareBuilder().
+		RegisterError(http.StatusInternalServerError, []byte("<html>"+large+"</html>")).
+		RegisterError(http.StatusNotFound, []byte("not found")).Build())
+	// 外层已经设置过的 Vary 不会重复添加
+	s.Use(func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			ctx.Resp.Header().Set("Vary", "accept-encoding")
+			next(ctx)
+		}
+	})
+	s.Use(recovery.NewMiddlewareBuilder().Build())
+	s.Use(NewMiddlewareBuilder().MinSize(100).Build())
+	s.Get("/large", func(ctx *context.Context) {
+		ctx.String(http.StatusOK, large)
+	})
+	s.Get("/small", func(ctx *context.Context) {
+		ctx.String(http.StatusOK, "hello")
+	})
+	s.Get("/image", func(ctx *context.Context) {
+		ctx.Blob(http.StatusOK, "image/png", []byte(large))
+	})
+	s.Get("/encoded", func(ctx *context.Context) {
+		ctx.Resp.Header().Set("Content-Encoding", "br")
+		ctx.String(http.StatusOK, large)
+	})
+	s.Get("/problem", func(ctx *context.Context) {
+		ctx.Blob(http.StatusBadRequest, "application/problem+json", []byte(`{"detail":"`+large+`"}`))
+	})
+	s.Get("/error", func(ctx *context.Context) {
+		ctx.String(http.StatusInternalServerError, large)
+	})
+	s.Get("/not-found", func(ctx *context.Context) {
+		ctx.String(http.StatusNotFound, large)
+	})
+	s.Get("/stream", func(ctx *context.Context) {
+		_ = ctx.Stream("text/plain", func(w io.Writer) error {
+			for i := 0; i < 3; i++ {
+				_, _ = io.WriteString(w, "chunk ")
+				ctx.Flush()
+			}
+			return nil
+		})
+	})
+	s.Get("/stream-panic", func(ctx *context.Context) {
+		_ = ctx.Stream("text/plain", func(w io.Writer) error {
+			_, _ = io.WriteString(w, large)
+			panic("stream")
+		})
+	})
+	s.Get("/sse", func(ctx *context.Context) {
+		_ = ctx.SSE().Send(context.SSEEvent{Data: large})
+	})
+
+	testCases := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		wantEncoding   string
+		wantBody       string
+	}{
+		{name: "gzip", path: "/large", acceptEncoding: "gzip, deflate", wantEncoding: "gzip", wantBody: large},
+		{name: "deflate preferred", path: "/large", acceptEncoding: "gzip;q=0.5, deflate", wantEncoding: "deflate", wantBody: large},
+		{name: "wildcard", path: "/large", acceptEncoding: "*", wantEncoding: "gzip", wantBody: large},
+		{name: "refused", path: "/large", acceptEncoding: "gzip;q=0, deflate;q=0", wantBody: large},
+		{name: "identity", path: "/large", acceptEncoding: "identity", wantBody: large},
+		{name: "no accept encoding", path: "/large", wantBody: large},
+		{name: "below threshold", path: "/small", acceptEncoding: "gzip", wantBody: "hello"},
+		{name: "not compressible", path: "/image", acceptEncoding: "gzip", wantBody: large},
+		{name: "already encoded", path: "/encoded", acceptEncoding: "gzip", wantEncoding: "br", wantBody: large},
+		{name: "suffix type", path: "/problem", acceptEncoding: "gzip", wantEncoding: "gzip", wantBody: `{"detail":"` + large + `"}`},
+		{name: "replaced by outer middleware", path: "/error", acceptEncoding: "gzip", wantEncoding: "gzip", wantBody: "<html>" + large + "</html>"},
+		{name: "replaced below threshold", path: "/not-found", acceptEncoding: "gzip", wantBody: "not found"},
+		{name: "stream", path: "/stream", acceptEncoding: "gzip", wantEncoding: "gzip", wantBody: "chunk chunk chunk "},
+		// panic 之前写出去的压缩流也要正常结束
+		{name: "stream panic", path: "/stream-panic", acceptEncoding: "gzip", wantEncoding: "gzip", wantBody: large},
+		{name: "sse", path: "/sse", acceptEncoding: "gzip", wantBody: "data: " + large + "\n\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, []string{"accept-encoding"}, recorder.Header().Values("Vary"))
+			assert.Equal(t, tc.wantEncoding, recorder.Header().Get("Content-Encoding"))
+
+			size := recorder.Body.Len()
+			var body io.Reader = recorder.Body
+			switch tc.wantEncoding {
+			case "gzip":
+				r, err := gzip.NewReader(body)
+				require.NoError(t, err)
+				body = r
+			case "deflate":
+				// zlib 头的第一个字节是 0x78
+				require.Greater(t, size, 0)
+				assert.Equal(t, byte(0x78), recorder.Body.Bytes()[0])
+				r, err := zlib.NewReader(body)
+				require.NoError(t, err)
+				body = r
+			}
+			data, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantBody, string(data))
+			if cl := recorder.Header().Get("Content-Length"); cl != "" {
+				assert.Equal(t, strconv.Itoa(size), cl)
+			}
+		})
+	}
+}
+
+func BenchmarkMiddlewareBuilder_Build(b *testing.B) {
+	body := []byte(strings.Repeat("hello world ", 200))
+	s := sepweb.NewHttpServer()
+	s.Use(NewMiddlewareBuilder().Build())
+	s.Get("/", func(ctx *context.Context) {
+		ctx.Blob(http.StatusOK, "text/plain", body)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
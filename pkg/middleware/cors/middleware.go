@@ -0,0 +1,224 @@
+package cors
+
+import (
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MiddlewareBuilder 构建 CORS 中间件。
+// 它需要通过 Server.Use 注册，这样预检请求在查找路由之前就会被处理，不需要注册 OPTIONS 路由
+type MiddlewareBuilder struct {
+	allowAll         bool
+	origins          map[string]struct{}
+	wildcards        []wildcard
+	regexps          []*regexp.Regexp
+	originFunc       func(origin string) bool
+	methods          []string
+	headers          []string
+	exposeHeaders    []string
+	allowCredentials bool
+	maxAge           time.Duration
+}
+
+// wildcard 是 https://*.example.com 这样的模式，* 匹配至少一个字符
+type wildcard struct {
+	prefix string
+	suffix string
+}
+
+func (w wildcard) match(origin string) bool {
+	return len(origin) > len(w.prefix)+len(w.suffix) &&
+		strings.HasPrefix(origin, w.prefix) && strings.HasSuffix(origin, w.suffix)
+}
+
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		origins: make(map[string]struct{}),
+		methods: []string{http.MethodGet, http.MethodHead, http.MethodPost,
+			http.MethodPut, http.MethodPatch, http.MethodDelete},
+	}
+}
+
+// AllowOrigins 设置允许的来源，可以是完整的来源，比如 https://example.com，
+// 也可以带一个通配符，比如 https://*.example.com。单独的 * 表示允许所有来源
+func (m *MiddlewareBuilder) AllowOrigins(origins ...string) *MiddlewareBuilder {
+	for _, origin := range origins {
+		origin = strings.ToLower(origin)
+		if origin == "*" {
+			m.allowAll = true
+			continue
+		}
+		if i := strings.IndexByte(origin, '*'); i >= 0 {
+			m.wildcards = append(m.wildcards, wildcard{prefix: origin[:i], suffix: origin[i+1:]})
+			continue
+		}
+		m.origins[origin] = struct{}{}
+	}
+	return m
+}
+
+// AllowOriginRegexps 允许匹配这些正则表达式的来源，注意正则表达式需要用 ^ 和 $ 锚定
+func (m *MiddlewareBuilder) AllowOriginRegexps(res ...*regexp.Regexp) *MiddlewareBuilder {
+	m.regexps = append(m.regexps, res...)
+	return m
+}
+
+// AllowOriginFunc 允许 fn 返回 true 的来源
+func (m *MiddlewareBuilder) AllowOriginFunc(fn func(origin string) bool) *MiddlewareBuilder {
+	m.originFunc = fn
+	return m
+}
+
+// AllowMethods 设置预检请求允许的方法，默认是 GET、HEAD、POST、PUT、PATCH 和 DELETE
+func (m *MiddlewareBuilder) AllowMethods(methods ...string) *MiddlewareBuilder {
+	m.methods = methods
+	return m
+}
+
+// AllowHeaders 设置预检请求允许的请求头。不设置的话，允许预检请求里声明的所有请求头
+func (m *MiddlewareBuilder) AllowHeaders(headers ...string) *MiddlewareBuilder {
+	m.headers = headers
+	return m
+}
+
+// ExposeHeaders 设置允许浏览器里的脚本读取的响应头
+func (m *MiddlewareBuilder) ExposeHeaders(headers ...string) *MiddlewareBuilder {
+	m.exposeHeaders = headers
+	return m
+}
+
+// AllowCredentials 允许跨域请求带上 cookie 等凭证。
+// 这时候即便允许所有来源，也会回写具体的来源，而不是 *
+func (m *MiddlewareBuilder) AllowCredentials(allow bool) *MiddlewareBuilder {
+	m.allowCredentials = allow
+	return m
+}
+
+// MaxAge 设置浏览器可以缓存预检结果多久
+func (m *MiddlewareBuilder) MaxAge(age time.Duration) *MiddlewareBuilder {
+	m.maxAge = age
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			header := ctx.Resp.Header()
+			// 允许所有来源又不带凭证的时候，响应和来源无关，不需要 Vary
+			if !m.allowAll || m.allowCredentials {
+				middleware.AddVary(header, "Origin")
+			}
+			origin := ctx.Req.Header.Get("Origin")
+			preflight := ctx.Req.Method == http.MethodOptions &&
+				ctx.Req.Header.Get("Access-Control-Request-Method") != ""
+			if preflight {
+				middleware.AddVary(header, "Access-Control-Request-Method")
+				middleware.AddVary(header, "Access-Control-Request-Headers")
+			}
+			if origin == "" {
+				next(ctx)
+				return
+			}
+			allowed := m.allowOrigin(origin)
+			if preflight {
+				// 不允许的预检请求也直接返回，只是不带 CORS 的响应头，浏览器会拒绝真正的请求
+				if allowed && m.handlePreflight(ctx, header) {
+					m.setAllowOrigin(header, origin)
+				}
+				ctx.AbortWithStatus(http.StatusNoContent)
+				return
+			}
+			if allowed {
+				m.setAllowOrigin(header, origin)
+				if len(m.exposeHeaders) > 0 {
+					header.Set("Access-Control-Expose-Headers", strings.Join(m.exposeHeaders, ", "))
+				}
+			}
+			next(ctx)
+		}
+	}
+}
+
+// handlePreflight 检查预检请求声明的方法和请求头，都允许的时候写入对应的响应头
+func (m *MiddlewareBuilder) handlePreflight(ctx *context.Context, header http.Header) bool {
+	method := ctx.Req.Header.Get("Access-Control-Request-Method")
+	if !containsFold(m.methods, method) {
+		return false
+	}
+	reqHeaders := parseHeaderList(ctx.Req.Header.Values("Access-Control-Request-Headers"))
+	if m.headers != nil {
+		for _, h := range reqHeaders {
+			if !containsFold(m.headers, h) {
+				return false
+			}
+		}
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(m.methods, ", "))
+	if len(reqHeaders) > 0 {
+		// 只回写预检请求声明过的请求头，避免把整个允许列表暴露出去
+		header.Set("Access-Control-Allow-Headers", strings.Join(reqHeaders, ", "))
+	}
+	if m.maxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(m.maxAge.Seconds())))
+	}
+	return true
+}
+
+func (m *MiddlewareBuilder) setAllowOrigin(header http.Header, origin string) {
+	if m.allowAll && !m.allowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	if m.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (m *MiddlewareBuilder) allowOrigin(origin string) bool {
+	if m.allowAll {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if _, ok := m.origins[lower]; ok {
+		return true
+	}
+	for _, w := range m.wildcards {
+		if w.match(lower) {
+			return true
+		}
+	}
+	for _, re := range m.regexps {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return m.originFunc != nil && m.originFunc(origin)
+}
+
+func parseHeaderList(vals []string) []string {
+	var res []string
+	for _, v := range vals {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				res = append(res, item)
+			}
+		}
+	}
+	return res
+}
+
+func containsFold(list []string, val string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, val) {
+			return true
+		}
+	}
+	return false
+}
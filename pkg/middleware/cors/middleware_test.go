@@ -0,0 +1,171 @@
+package cors
+
+import (
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	newServer := func(b *MiddlewareBuilder) *sepweb.HttpServer {
+		s := sepweb.NewHttpServer()
+		s.Use(b.Build())
+		s.Post("/users", func(ctx *context.Context) {
+			ctx.String(http.StatusCreated, "created")
+		})
+		return s
+	}
+	restricted := newServer(NewMiddlewareBuilder().
+		AllowOrigins("https://app.example.com", "https://*.example.org").
+		AllowOriginRegexps(regexp.MustCompile(`^http://localhost:\d+$`)).
+		AllowOriginFunc(func(origin string) bool {
+			return origin == "https://partner.test"
+		}).
+		AllowMethods(http.MethodGet, http.MethodPost).
+		AllowHeaders("Content-Type", "Authorization").
+		ExposeHeaders("X-Request-ID").
+		AllowCredentials(true).
+		MaxAge(10 * time.Minute))
+	public := newServer(NewMiddlewareBuilder().AllowOrigins("*"))
+
+	testCases := []struct {
+		name       string
+		server     *sepweb.HttpServer
+		method     string
+		header     map[string]string
+		wantCode   int
+		wantHeader map[string]string
+	}{
+		{
+			name:     "preflight",
+			server:   restricted,
+			method:   http.MethodOptions,
+			header:   map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "content-type"},
+			wantCode: http.StatusNoContent,
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Methods":     "GET, POST",
+				"Access-Control-Allow-Headers":     "content-type",
+				"Access-Control-Max-Age":           "600",
+				"Vary":                             "Origin, Access-Control-Request-Method, Access-Control-Request-Headers",
+			},
+		},
+		{
+			name:     "preflight method not allowed",
+			server:   restricted,
+			method:   http.MethodOptions,
+			header:   map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "DELETE"},
+			wantCode: http.StatusNoContent,
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:     "preflight header not allowed",
+			server:   restricted,
+			method:   http.MethodOptions,
+			header:   map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "X-Secret"},
+			wantCode: http.StatusNoContent,
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:     "wildcard origin",
+			server:   restricted,
+			method:   http.MethodPost,
+			header:   map[string]string{"Origin": "https://shop.example.org"},
+			wantCode: http.StatusCreated,
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Origin":   "https://shop.example.org",
+				"Access-Control-Expose-Headers": "X-Request-ID",
+				"Vary":                          "Origin",
+			},
+		},
+		{
+			name:     "wildcard does not match bare domain",
+			server:   restricted,
+			method:   http.MethodPost,
+			header:   map[string]string{"Origin": "https://.example.org"},
+			wantCode: http.StatusCreated,
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:     "regexp origin",
+			server:   restricted,
+			method:   http.MethodPost,
+			header:   map[string]string{"Origin": "http://localhost:3000"},
+			wantCode: http.StatusCreated,
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Origin": "http://localhost:3000",
+			},
+		},
+		{
+			name:     "func origin",
+			server:   restricted,
+			method:   http.MethodPost,
+			header:   map[string]string{"Origin": "https://partner.test"},
+			wantCode: http.StatusCreated,
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Origin": "https://partner.test",
+			},
+		},
+		{
+			name:     "origin not allowed",
+			server:   restricted,
+			method:   http.MethodPost,
+			header:   map[string]string{"Origin": "https://evil.test"},
+			wantCode: http.StatusCreated,
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Origin": "",
+				"Vary":                        "Origin",
+			},
+		},
+		{
+			name:     "public",
+			server:   public,
+			method:   http.MethodPost,
+			header:   map[string]string{"Origin": "https://any.test"},
+			wantCode: http.StatusCreated,
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Origin":      "*",
+				"Access-Control-Allow-Credentials": "",
+				"Vary":                             "",
+			},
+		},
+		{
+			name:     "public preflight",
+			server:   public,
+			method:   http.MethodOptions,
+			header:   map[string]string{"Origin": "https://any.test", "Access-Control-Request-Method": "PUT"},
+			wantCode: http.StatusNoContent,
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "GET, HEAD, POST, PUT, PATCH, DELETE",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/users", nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			recorder := httptest.NewRecorder()
+			tc.server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			for k, v := range tc.wantHeader {
+				assert.Equal(t, v, strings.Join(recorder.Header().Values(k), ", "), k)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package csrf
+
+import (
+	stdctx "context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"github.com/igevin/sepweb/pkg/template"
+	htmltemplate "html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	DefaultHeader    = "X-CSRF-Token"
+	DefaultFieldName = "csrf_token"
+	// DefaultMultipartMemory 是解析 multipart 表单时最多放在内存里的大小，超过的部分会写到临时文件
+	DefaultMultipartMemory = 32 << 20
+)
+
+var (
+	ErrTokenMissing = errors.New("csrf: 请求里没有 token")
+	ErrTokenInvalid = errors.New("csrf: token 不正确")
+	ErrBadOrigin    = errors.New("csrf: 请求的来源不可信")
+	ErrNoToken      = errors.New("csrf: 无法获得 token")
+)
+
+var tokenKey = context.NewKey[string]("csrf-token")
+
+// fieldKey 记录表单字段的名字，模板函数需要用它生成隐藏字段
+var fieldKey = context.NewKey[string]("csrf-field")
+
+type MiddlewareBuilder struct {
+	storage        Storage
+	header         string
+	fieldName      string
+	trustedOrigins map[string]struct{}
+	exemptPaths    map[string]struct{}
+	exemptFunc     func(ctx *context.Context) bool
+	maxMemory      int64
+}
+
+func NewMiddlewareBuilder(storage Storage) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		storage:        storage,
+		header:         DefaultHeader,
+		fieldName:      DefaultFieldName,
+		trustedOrigins: make(map[string]struct{}),
+		exemptPaths:    make(map[string]struct{}),
+		maxMemory:      DefaultMultipartMemory,
+	}
+}
+
+// Header 设置提交 token 的请求头，一般是 AJAX 请求使用
+func (m *MiddlewareBuilder) Header(name string) *MiddlewareBuilder {
+	m.header = name
+	return m
+}
+
+// FieldName 设置提交 token 的表单字段
+func (m *MiddlewareBuilder) FieldName(name string) *MiddlewareBuilder {
+	m.fieldName = name
+	return m
+}
+
+// MultipartMemory 设置从 multipart 表单里读取 token 的时候，最多把多少数据放在内存里
+func (m *MiddlewareBuilder) MultipartMemory(size int64) *MiddlewareBuilder {
+	m.maxMemory = size
+	return m
+}
+
+// TrustedOrigins 设置除了同源之外，还允许哪些来源提交请求，比如 https://admin.example.com
+func (m *MiddlewareBuilder) TrustedOrigins(origins ...string) *MiddlewareBuilder {
+	for _, origin := range origins {
+		m.trustedOrigins[strings.ToLower(origin)] = struct{}{}
+	}
+	return m
+}
+
+// Exempt 不检查这些路径，比如接收第三方回调的接口。
+// 中间件一般通过 Server.Use 注册，这时候还没有匹配路由，所以比较的是请求的路径
+func (m *MiddlewareBuilder) Exempt(paths ...string) *MiddlewareBuilder {
+	for _, p := range paths {
+		m.exemptPaths[p] = struct{}{}
+	}
+	return m
+}
+
+// ExemptFunc 返回 true 的请求不检查
+func (m *MiddlewareBuilder) ExemptFunc(fn func(ctx *context.Context) bool) *MiddlewareBuilder {
+	m.exemptFunc = fn
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			token, err := m.token(ctx)
+			if err == nil {
+				context.Set(ctx, tokenKey, token)
+				context.Set(ctx, fieldKey, m.fieldName)
+			}
+			if safeMethod(ctx.Req.Method) || m.exempt(ctx) {
+				next(ctx)
+				return
+			}
+			if err == nil {
+				err = m.verify(ctx, token)
+			}
+			if err != nil {
+				ctx.AbortWithError(http.StatusForbidden, err)
+				ctx.RespData = []byte(http.StatusText(http.StatusForbidden))
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+// token 取出已有的 token，没有的时候生成一个新的并保存
+func (m *MiddlewareBuilder) token(ctx *context.Context) (string, error) {
+	token, err := m.storage.Load(ctx)
+	if err != nil {
+		return "", ErrNoToken
+	}
+	if token != "" {
+		return token, nil
+	}
+	bs := make([]byte, 32)
+	if _, err = rand.Read(bs); err != nil {
+		return "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(bs)
+	if err = m.storage.Save(ctx, token); err != nil {
+		return "", ErrNoToken
+	}
+	return token, nil
+}
+
+func (m *MiddlewareBuilder) verify(ctx *context.Context, token string) error {
+	if !m.checkOrigin(ctx) {
+		return ErrBadOrigin
+	}
+	submitted := ctx.Req.Header.Get(m.header)
+	if submitted == "" {
+		submitted = m.formToken(ctx)
+	}
+	if submitted == "" {
+		return ErrTokenMissing
+	}
+	if subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+// formToken 从表单里读取 token。Context.FormValue 不会解析 multipart 的请求体，上传文件的表单需要单独处理
+func (m *MiddlewareBuilder) formToken(ctx *context.Context) string {
+	ct, _, _ := mime.ParseMediaType(ctx.Req.Header.Get("Content-Type"))
+	if ct != "multipart/form-data" {
+		token, _ := ctx.FormValue(m.fieldName).ToString()
+		return token
+	}
+	if err := ctx.Req.ParseMultipartForm(m.maxMemory); err != nil {
+		return ""
+	}
+	if vals := ctx.Req.MultipartForm.Value[m.fieldName]; len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// checkOrigin 优先检查 Origin，没有的话再检查 Referer。
+// HTTPS 的请求两者都没有的时候拒绝，因为浏览器只会在 HTTPS 降级到 HTTP 的时候才不带 Referer
+func (m *MiddlewareBuilder) checkOrigin(ctx *context.Context) bool {
+	source := ctx.Req.Header.Get("Origin")
+	if source == "" {
+		source = ctx.Req.Header.Get("Referer")
+	}
+	if source == "" {
+		return ctx.Req.TLS == nil
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		// 包括 Origin: null
+		return false
+	}
+	if strings.EqualFold(u.Host, ctx.Req.Host) {
+		return true
+	}
+	_, ok := m.trustedOrigins[strings.ToLower(u.Scheme+"://"+u.Host)]
+	return ok
+}
+
+func (m *MiddlewareBuilder) exempt(ctx *context.Context) bool {
+	if _, ok := m.exemptPaths[ctx.Req.URL.Path]; ok {
+		return true
+	}
+	return m.exemptFunc != nil && m.exemptFunc(ctx)
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// Token 返回当前请求的 token，可以放到页面里给 AJAX 请求使用
+func Token(ctx stdctx.Context) string {
+	token, _ := ctx.Value(tokenKey).(string)
+	return token
+}
+
+// TemplateFuncs 返回 csrfField 和 csrfToken 两个模板函数，
+// 需要在加载模板之前通过 GoTemplateEngine.ContextFuncs 注册
+func TemplateFuncs() map[string]template.ContextFunc {
+	return map[string]template.ContextFunc{
+		"csrfField": func(ctx stdctx.Context) any {
+			field, _ := ctx.Value(fieldKey).(string)
+			if field == "" {
+				return htmltemplate.HTML("")
+			}
+			return htmltemplate.HTML(`<input type="hidden" name="` + htmltemplate.HTMLEscapeString(field) +
+				`" value="` + htmltemplate.HTMLEscapeString(Token(ctx)) + `">`)
+		},
+		"csrfToken": func(ctx stdctx.Context) any {
+			return Token(ctx)
+		},
+	}
+}
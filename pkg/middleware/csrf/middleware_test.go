@@ -0,0 +1,158 @@
+package csrf
+
+import (
+	"bytes"
+	stdctx "context"
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/keyring"
+	"github.com/igevin/sepweb/pkg/session"
+	"github.com/igevin/sepweb/pkg/session/cookie"
+	"github.com/igevin/sepweb/pkg/session/memory"
+	"github.com/igevin/sepweb/pkg/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	engine := &template.GoTemplateEngine{}
+	engine.ContextFuncs(TemplateFuncs())
+	require.NoError(t, engine.LoadFromFS(fstest.MapFS{
+		"form.gohtml": {Data: []byte(`<form method="post">{{ csrfField }}</form>`)},
+	}, "*.gohtml"))
+	kr, err := keyring.New([]byte("a secret with enough bytes"))
+	require.NoError(t, err)
+
+	m := &session.Manager{
+		SessCtxKey: "_sess",
+		Store:      memory.NewStore(time.Minute),
+		Propagator: cookie.NewPropagator("sessid"),
+	}
+	_, err = m.Generate(stdctx.Background(), "sess-1")
+	require.NoError(t, err)
+
+	newServer := func(storage Storage, opts ...sepweb.ServerOption) *sepweb.HttpServer {
+		s := sepweb.NewHttpServer(append(opts, sepweb.ServerWithTemplateEngine(engine))...)
+		s.Use(NewMiddlewareBuilder(storage).
+			TrustedOrigins("https://admin.example.com").
+			Exempt("/webhook").Build())
+		s.Get("/form", func(ctx *context.Context) {
+			_ = ctx.Render("form.gohtml", nil)
+		})
+		s.Post("/form", func(ctx *context.Context) {
+			ctx.String(http.StatusOK, "saved")
+		})
+		s.Post("/upload", func(ctx *context.Context) {
+			// 验证 token 的时候解析过的表单，handler 依旧可以正常读取
+			f, _, err := ctx.Req.FormFile("file")
+			if err != nil {
+				ctx.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			defer f.Close()
+			data, _ := io.ReadAll(f)
+			ctx.String(http.StatusOK, string(data))
+		})
+		s.Post("/webhook", func(ctx *context.Context) {
+			ctx.String(http.StatusOK, "received")
+		})
+		return s
+	}
+	fieldRe := regexp.MustCompile(`<input type="hidden" name="csrf_token" value="([^"]+)">`)
+
+	testCases := []struct {
+		name    string
+		server  *sepweb.HttpServer
+		cookies []*http.Cookie
+	}{
+		{
+			name:    "session",
+			server:  newServer(SessionStorage(m)),
+			cookies: []*http.Cookie{{Name: "sessid", Value: "sess-1"}},
+		},
+		{
+			name:   "cookie",
+			server: newServer(CookieStorage("", nil)),
+		},
+		{
+			name:   "signed cookie",
+			server: newServer(CookieStorage("", nil), sepweb.ServerWithKeyring(kr)),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			serve := func(req *http.Request, cookies []*http.Cookie) *httptest.ResponseRecorder {
+				for _, c := range cookies {
+					req.AddCookie(c)
+				}
+				recorder := httptest.NewRecorder()
+				tc.server.ServeHTTP(recorder, req)
+				return recorder
+			}
+			recorder := serve(httptest.NewRequest(http.MethodGet, "/form", nil), tc.cookies)
+			require.Equal(t, http.StatusOK, recorder.Code)
+			matches := fieldRe.FindStringSubmatch(recorder.Body.String())
+			require.Len(t, matches, 2, recorder.Body.String())
+			token := matches[1]
+			cookies := append(recorder.Result().Cookies(), tc.cookies...)
+
+			post := func(token, origin string, header bool) int {
+				form := url.Values{}
+				if !header {
+					form.Set(DefaultFieldName, token)
+				}
+				req := httptest.NewRequest(http.MethodPost, "/form", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				if header {
+					req.Header.Set(DefaultHeader, token)
+				}
+				if origin != "" {
+					req.Header.Set("Origin", origin)
+				}
+				return serve(req, cookies).Code
+			}
+			assert.Equal(t, http.StatusOK, post(token, "", false))
+			assert.Equal(t, http.StatusOK, post(token, "http://example.com", true))
+			assert.Equal(t, http.StatusOK, post(token, "https://admin.example.com", false))
+			assert.Equal(t, http.StatusForbidden, post(token, "https://evil.test", false))
+			assert.Equal(t, http.StatusForbidden, post(token, "null", false))
+			assert.Equal(t, http.StatusForbidden, post("", "", false))
+			assert.Equal(t, http.StatusForbidden, post(token+"x", "", true))
+
+			upload := func(token string) *httptest.ResponseRecorder {
+				body := &bytes.Buffer{}
+				w := multipart.NewWriter(body)
+				require.NoError(t, w.WriteField(DefaultFieldName, token))
+				fw, err := w.CreateFormFile("file", "a.txt")
+				require.NoError(t, err)
+				_, _ = fw.Write([]byte("content"))
+				require.NoError(t, w.Close())
+				req := httptest.NewRequest(http.MethodPost, "/upload", body)
+				req.Header.Set("Content-Type", w.FormDataContentType())
+				return serve(req, cookies)
+			}
+			recorder = upload(token)
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.Equal(t, "content", recorder.Body.String())
+			assert.Equal(t, http.StatusForbidden, upload(token+"x").Code)
+
+			// 第一次访问的时候 token 还不存在，提交的请求会被拒绝
+			req := httptest.NewRequest(http.MethodPost, "/form", nil)
+			req.Header.Set(DefaultHeader, token)
+			assert.Equal(t, http.StatusForbidden, serve(req, nil).Code)
+
+			req = httptest.NewRequest(http.MethodPost, "/webhook", nil)
+			assert.Equal(t, http.StatusOK, serve(req, nil).Code)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package csrf
+
+import (
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/session"
+	"net/http"
+)
+
+// Storage 决定 token 保存在哪里
+type Storage interface {
+	// Load 取出之前保存的 token，没有的时候返回空字符串
+	Load(ctx *context.Context) (string, error)
+	Save(ctx *context.Context, token string) error
+}
+
+const sessionKey = "_csrf_token"
+
+// SessionStorage 把 token 保存在 session 里，也就是同步器 token 模式。
+// 没有 session 的请求拿不到 token，提交表单的时候会被拒绝
+func SessionStorage(m *session.Manager) Storage {
+	return &sessionStorage{m: m}
+}
+
+type sessionStorage struct {
+	m *session.Manager
+}
+
+func (s *sessionStorage) Load(ctx *context.Context) (string, error) {
+	sess, err := s.m.GetSession(ctx)
+	if err != nil {
+		return "", err
+	}
+	// session 里没有 token 的时候按照没有处理，生成一个新的
+	token, _ := sess.Get(ctx.Req.Context(), sessionKey)
+	return token, nil
+}
+
+func (s *sessionStorage) Save(ctx *context.Context, token string) error {
+	sess, err := s.m.GetSession(ctx)
+	if err != nil {
+		return err
+	}
+	return sess.Set(ctx.Req.Context(), sessionKey, token)
+}
+
+// DefaultCookieName 是 CookieStorage 默认使用的 cookie
+const DefaultCookieName = "_csrf"
+
+// CookieStorage 把 token 保存在 cookie 里，也就是双重提交 cookie 模式。
+// 配置了 ServerWithKeyring 的时候 cookie 会被签名，避免子域名注入伪造的 cookie。
+// opt 可以修改 cookie 的属性，比如设置 Secure
+func CookieStorage(name string, opt func(c *http.Cookie)) Storage {
+	if name == "" {
+		name = DefaultCookieName
+	}
+	return &cookieStorage{name: name, opt: opt}
+}
+
+type cookieStorage struct {
+	name string
+	opt  func(c *http.Cookie)
+}
+
+func (c *cookieStorage) Load(ctx *context.Context) (string, error) {
+	var val context.StringValue
+	if ctx.Keyring != nil {
+		val = ctx.SignedCookie(c.name)
+	} else {
+		val = ctx.Cookie(c.name)
+	}
+	// cookie 不存在或者被篡改的时候重新生成
+	token, _ := val.ToString()
+	return token, nil
+}
+
+func (c *cookieStorage) Save(ctx *context.Context, token string) error {
+	cookie := &http.Cookie{
+		Name:     c.name,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if c.opt != nil {
+		c.opt(cookie)
+	}
+	if ctx.Keyring != nil {
+		return ctx.SetSignedCookie(cookie)
+	}
+	ctx.SetCookie(cookie)
+	return nil
+}
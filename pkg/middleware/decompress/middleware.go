@@ -0,0 +1,130 @@
+package decompress
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var gzipPool sync.Pool
+
+// MiddlewareBuilder 透明地解压 Content-Encoding 为 gzip 或者 deflate 的请求体。
+// 解压之后的大小超过限制的时候，读取请求体会得到 context.ErrBodyTooLarge
+type MiddlewareBuilder struct {
+	maxBytes int64
+}
+
+// NewMiddlewareBuilder 创建中间件，maxBytes 是解压之后的请求体最多允许多少字节，
+// 必须设置，否则一个很小的压缩包就可以解压出几个 G 的数据
+func NewMiddlewareBuilder(maxBytes int64) *MiddlewareBuilder {
+	return &MiddlewareBuilder{maxBytes: maxBytes}
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			encoding := strings.ToLower(strings.TrimSpace(ctx.Req.Header.Get("Content-Encoding")))
+			if encoding == "" || encoding == "identity" ||
+				ctx.Req.Body == nil || ctx.Req.Body == http.NoBody {
+				next(ctx)
+				return
+			}
+			if encoding == "x-gzip" {
+				encoding = "gzip"
+			}
+			if encoding != "gzip" && encoding != "deflate" {
+				// RFC 7694：告诉客户端支持哪些编码
+				ctx.Resp.Header().Set("Accept-Encoding", "gzip, deflate")
+				ctx.AbortWithStatus(http.StatusUnsupportedMediaType)
+				ctx.RespData = []byte(http.StatusText(http.StatusUnsupportedMediaType))
+				return
+			}
+			body := &decompressBody{src: ctx.Req.Body, encoding: encoding}
+			ctx.Req.Body = body
+			ctx.Req.Header.Del("Content-Encoding")
+			ctx.Req.Header.Del("Content-Length")
+			ctx.Req.ContentLength = -1
+			ctx.LimitBody(m.maxBytes)
+			next(ctx)
+			body.release()
+		}
+	}
+}
+
+// decompressBody 在第一次读取的时候才创建解压器，没有读取请求体的 handler 不需要付出解压的代价
+type decompressBody struct {
+	// mu 在读取的时候一直持有，release 通过它判断请求体是不是还在被读取
+	mu       sync.Mutex
+	src      io.ReadCloser
+	encoding string
+	r        io.Reader
+	gz       *gzip.Reader
+	err      error
+}
+
+func (d *decompressBody) Read(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.r == nil && d.err == nil {
+		d.r, d.err = d.newReader()
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+func (d *decompressBody) newReader() (io.Reader, error) {
+	if d.encoding == "gzip" {
+		gz, ok := gzipPool.Get().(*gzip.Reader)
+		var err error
+		if ok {
+			err = gz.Reset(d.src)
+		} else {
+			gz, err = gzip.NewReader(d.src)
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.gz = gz
+		return gz, nil
+	}
+	// 规范里的 deflate 是带 zlib 头的格式，但是也有客户端直接发送裸的 deflate 数据
+	br := bufio.NewReader(d.src)
+	if header, err := br.Peek(2); err == nil && isZlibHeader(header) {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+func (d *decompressBody) Close() error {
+	return d.src.Close()
+}
+
+// release 把 gzip.Reader 放回池子，在 handler 返回之后调用。
+// handler 返回之后请求体还可能在被其它 goroutine 读取，比如被 timeout 中间件放弃的 handler，
+// 这时候 gzip.Reader 不放回池子，留给它继续使用
+func (d *decompressBody) release() {
+	if !d.mu.TryLock() {
+		return
+	}
+	defer d.mu.Unlock()
+	if d.gz != nil {
+		gzipPool.Put(d.gz)
+		d.gz = nil
+		d.r = nil
+		d.err = io.ErrClosedPipe
+	}
+}
+
+func isZlibHeader(h []byte) bool {
+	return h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0
+}
@@ -0,0 +1,134 @@
+package decompress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/middleware/timeout"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	type event struct {
+		Name string `json:"name"`
+	}
+	s := sepweb.NewHttpServer()
+	s.Use(NewMiddlewareBuilder(64).Build())
+	s.Post("/events", func(ctx *context.Context) {
+		e := &event{}
+		if err := ctx.BindJson(e); err != nil {
+			if err != context.ErrBodyTooLarge {
+				ctx.RespStatusCode = http.StatusBadRequest
+			}
+			return
+		}
+		ctx.String(http.StatusOK, e.Name)
+	})
+
+	compress := func(encoding, data string) []byte {
+		buf := &bytes.Buffer{}
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(buf)
+		case "zlib":
+			w = zlib.NewWriter(buf)
+		case "flate":
+			w, _ = flate.NewWriter(buf, flate.DefaultCompression)
+		default:
+			return []byte(data)
+		}
+		_, _ = io.WriteString(w, data)
+		_ = w.Close()
+		return buf.Bytes()
+	}
+	payload := `{"name":"login"}`
+
+	testCases := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+		wantCode        int
+		wantBody        string
+	}{
+		{name: "gzip", contentEncoding: "gzip", body: compress("gzip", payload), wantCode: http.StatusOK, wantBody: "login"},
+		{name: "x-gzip", contentEncoding: "x-gzip", body: compress("gzip", payload), wantCode: http.StatusOK, wantBody: "login"},
+		{name: "deflate", contentEncoding: "deflate", body: compress("zlib", payload), wantCode: http.StatusOK, wantBody: "login"},
+		{name: "raw deflate", contentEncoding: "deflate", body: compress("flate", payload), wantCode: http.StatusOK, wantBody: "login"},
+		{name: "identity", body: []byte(payload), wantCode: http.StatusOK, wantBody: "login"},
+		{
+			name:            "zip bomb",
+			contentEncoding: "gzip",
+			body:            compress("gzip", `{"name":"`+strings.Repeat("a", 1<<20)+`"}`),
+			wantCode:        http.StatusRequestEntityTooLarge,
+		},
+		{name: "corrupted", contentEncoding: "gzip", body: []byte(payload), wantCode: http.StatusBadRequest},
+		{name: "unsupported", contentEncoding: "br", body: []byte(payload), wantCode: http.StatusUnsupportedMediaType, wantBody: "Unsupported Media Type"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(tc.body))
+			if tc.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", tc.contentEncoding)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Abandoned(t *testing.T) {
+	payload := strings.Repeat("hello ", 100)
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	_, _ = io.WriteString(w, payload)
+	_ = w.Close()
+	data := buf.Bytes()
+
+	started := make(chan struct{})
+	result := make(chan string, 1)
+	s := sepweb.NewHttpServer()
+	// timeout 在里面，超时之后 handler 还会继续读取请求体
+	s.Use(NewMiddlewareBuilder(1024).Build(), timeout.NewMiddlewareBuilder(20*time.Millisecond).Build())
+	s.Post("/upload", func(ctx *context.Context) {
+		close(started)
+		body, err := io.ReadAll(ctx.Req.Body)
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		result <- string(body)
+	})
+
+	r, pw := io.Pipe()
+	go func() {
+		_, _ = pw.Write(data[:len(data)/2])
+	}()
+	req := httptest.NewRequest(http.MethodPost, "/upload", r)
+	req.Header.Set("Content-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	<-started
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	// 请求已经结束，被放弃的 handler 依旧可以读完请求体
+	_, _ = pw.Write(data[len(data)/2:])
+	_ = pw.Close()
+	select {
+	case got := <-result:
+		assert.Equal(t, payload, got)
+	case <-time.After(time.Second):
+		t.Fatal("handler 没有读完请求体")
+	}
+}
@@ -21,6 +21,15 @@ func createResp(name, page string) []byte {
 	return buffer.Bytes()
 }
 
+func createResp403() []byte {
+	page := `
+<html>
+	<h1>403 Forbidden</h1>
+</html>
+`
+	return createResp("403", page)
+}
+
 func createResp404() []byte {
 	page := `
 <html>
@@ -41,6 +50,7 @@ func createResp500() []byte {
 
 func CreateHttpErrorHandleMiddleware() middleware.Middleware {
 	return NewMiddlewareBuilder().
+		RegisterError(http.StatusForbidden, createResp403()).
 		RegisterError(http.StatusNotFound, createResp404()).
 		RegisterError(http.StatusInternalServerError, createResp500()).
 		Build()
@@ -0,0 +1,56 @@
+package etag
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"net/http"
+)
+
+// MiddlewareBuilder 给缓冲的 GET 和 HEAD 响应计算 ETag，客户端的缓存依旧有效的时候返回 304。
+// handler 自己设置了 ETag 或者 Last-Modified 的时候，不会再计算，只检查条件请求。
+// ETag 是根据压缩之前的内容计算的，compress 中间件压缩响应的时候会把强 ETag 换成弱 ETag
+type MiddlewareBuilder struct {
+	weak bool
+}
+
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{}
+}
+
+// Weak 生成弱 ETag。内容语义相同但是字节可能不同的时候使用，比如响应会被不同的方式压缩
+func (m *MiddlewareBuilder) Weak() *MiddlewareBuilder {
+	m.weak = true
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			next(ctx)
+			if ctx.Req.Method != http.MethodGet && ctx.Req.Method != http.MethodHead {
+				return
+			}
+			if ctx.Streamed() || ctx.Hijacked() ||
+				(ctx.RespStatusCode != 0 && ctx.RespStatusCode != http.StatusOK) {
+				return
+			}
+			header := ctx.Resp.Header()
+			if header.Get("ETag") == "" && header.Get("Last-Modified") == "" {
+				ctx.SetETag(m.compute(ctx.RespData))
+			}
+			ctx.CheckNotModified()
+		}
+	}
+}
+
+func (m *MiddlewareBuilder) compute(data []byte) string {
+	sum := sha256.Sum256(data)
+	tag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	if m.weak {
+		return "W/" + tag
+	}
+	return tag
+}
@@ -0,0 +1,85 @@
+package etag
+
+import (
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"github.com/igevin/sepweb/pkg/middleware/compress"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	version := 1
+	newServer := func(b *MiddlewareBuilder, outer ...middleware.Middleware) *sepweb.HttpServer {
+		s := sepweb.NewHttpServer()
+		s.Use(append(outer, b.Build())...)
+		s.Get("/article", func(ctx *context.Context) {
+			ctx.String(http.StatusOK, "article")
+		})
+		s.Get("/missing", func(ctx *context.Context) {
+			ctx.String(http.StatusNotFound, "missing")
+		})
+		s.AddRoute(http.MethodPut, "/article", func(ctx *context.Context) {
+			ctx.SetETag(strconv.Quote(strconv.Itoa(version)))
+			if !ctx.CheckPrecondition() {
+				return
+			}
+			version++
+			ctx.SetETag(strconv.Quote(strconv.Itoa(version)))
+			ctx.RespStatusCode = http.StatusNoContent
+		})
+		return s
+	}
+	serve := func(s *sepweb.HttpServer, method, path string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	s := newServer(NewMiddlewareBuilder())
+	recorder := serve(s, http.MethodGet, "/article", nil)
+	etag := recorder.Header().Get("ETag")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, strings.HasPrefix(etag, `"`))
+
+	recorder = serve(s, http.MethodGet, "/article", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, recorder.Code)
+	assert.Empty(t, recorder.Body.String())
+	assert.Equal(t, etag, recorder.Header().Get("ETag"))
+
+	// 错误响应不计算 ETag
+	recorder = serve(s, http.MethodGet, "/missing", nil)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("ETag"))
+
+	// 乐观锁：只有基于最新版本的修改才会成功
+	assert.Equal(t, http.StatusNoContent, serve(s, http.MethodPut, "/article", map[string]string{"If-Match": `"1"`}).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, serve(s, http.MethodPut, "/article", map[string]string{"If-Match": `"1"`}).Code)
+	assert.Equal(t, 2, version)
+
+	weak := newServer(NewMiddlewareBuilder().Weak())
+	recorder = serve(weak, http.MethodGet, "/article", nil)
+	assert.Equal(t, "W/"+etag, recorder.Header().Get("ETag"))
+	recorder = serve(weak, http.MethodGet, "/article", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, recorder.Code)
+
+	// 压缩之后的表示只能使用弱 ETag
+	compressed := newServer(NewMiddlewareBuilder(), compress.NewMiddlewareBuilder().MinSize(0).Build())
+	recorder = serve(compressed, http.MethodGet, "/article", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, "W/"+etag, recorder.Header().Get("ETag"))
+	recorder = serve(compressed, http.MethodGet, "/article", nil)
+	assert.Equal(t, etag, recorder.Header().Get("ETag"))
+	recorder = serve(compressed, http.MethodGet, "/article",
+		map[string]string{"Accept-Encoding": "gzip", "If-None-Match": "W/" + etag})
+	assert.Equal(t, http.StatusNotModified, recorder.Code)
+}
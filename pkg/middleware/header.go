@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// AddVary 在响应的 Vary 里追加 field，已经有了，或者已经是 * 的时候不再重复添加
+func AddVary(header http.Header, field string) {
+	for _, v := range header.Values("Vary") {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item == "*" || strings.EqualFold(item, field) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", field)
+}
@@ -1,5 +1,27 @@
 package middleware
 
-import "github.com/igevin/sepweb/pkg/handler"
+import (
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+)
 
 type Middleware func(next handler.Handle) handler.Handle
+
+// Chain 把 mdls 按顺序套在 h 外面，第一个 middleware 在最外层。
+// 任何一环调用了 Context.Abort，后面的 middleware 和 h 都不会再执行
+func Chain(h handler.Handle, mdls ...Middleware) handler.Handle {
+	h = abortable(h)
+	for i := len(mdls) - 1; i >= 0; i-- {
+		h = abortable(mdls[i](h))
+	}
+	return h
+}
+
+func abortable(next handler.Handle) handler.Handle {
+	return func(ctx *context.Context) {
+		if ctx.IsAborted() {
+			return
+		}
+		next(ctx)
+	}
+}
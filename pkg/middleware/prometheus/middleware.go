@@ -0,0 +1,114 @@
+package prometheus
+
+import (
+	"bufio"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/metrics"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// unmatchedRoute 是没有命中任何路由的请求的 route 标签，避免把原始路径当成标签导致序列爆炸
+const unmatchedRoute = "unmatched"
+
+type MiddlewareBuilder struct {
+	registry *metrics.Registry
+	buckets  []float64
+}
+
+func NewMiddlewareBuilder(registry *metrics.Registry) *MiddlewareBuilder {
+	return &MiddlewareBuilder{registry: registry}
+}
+
+// Buckets 设置响应时间直方图的分桶，单位是秒，默认是 metrics.DefaultBuckets
+func (m *MiddlewareBuilder) Buckets(buckets ...float64) *MiddlewareBuilder {
+	m.buckets = buckets
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	requests := m.registry.NewCounter("http_requests_total",
+		"Total number of HTTP requests.", "method", "route", "status")
+	duration := m.registry.NewHistogram("http_request_duration_seconds",
+		"HTTP request latency in seconds.", m.buckets, "method", "route", "status")
+	inFlight := m.registry.NewGauge("http_requests_in_flight",
+		"Number of HTTP requests currently being served.", "method")
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			method := ctx.Req.Method
+			start := time.Now()
+			inFlight.Inc(method)
+			// 记录直接写到 Resp 上的响应码。rw 不会被换回去，
+			// 里面的中间件可能在它外面又包了一层，要留给 flushResp 使用，比如压缩
+			rw := &statusRecorder{ResponseWriter: ctx.Resp}
+			ctx.Resp = rw
+			// panicked 在 next 正常返回之后才会变成 false，panic 交给外层的 recovery 处理
+			panicked := true
+			defer func() {
+				inFlight.Dec(method)
+				route := ctx.MatchedRoute
+				if route == "" {
+					route = unmatchedRoute
+				}
+				code := rw.status
+				if code == 0 && panicked {
+					// 还没有写回任何东西，外层的 recovery 会返回 500
+					code = http.StatusInternalServerError
+				}
+				if code == 0 {
+					// 缓冲的响应要等到 flushResp 才会写回
+					code = ctx.RespStatusCode
+				}
+				status := statusClass(code)
+				requests.Inc(method, route, status)
+				duration.Observe(time.Since(start).Seconds(), method, route, status)
+			}()
+			next(ctx)
+			panicked = false
+		}
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := r.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// statusClass 把响应码归类成 2xx、4xx 这样的形式
+func statusClass(code int) string {
+	if code == 0 {
+		code = http.StatusOK
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
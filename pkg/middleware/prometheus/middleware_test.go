@@ -0,0 +1,58 @@
+package prometheus
+
+import (
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/metrics"
+	"github.com/igevin/sepweb/pkg/middleware/recovery"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	reg := metrics.NewRegistry()
+	s := sepweb.NewHttpServer()
+	// recovery 在外层，panic 的请求也要记成 5xx
+	s.Use(recovery.NewMiddlewareBuilder().Build())
+	s.Use(NewMiddlewareBuilder(reg).Buckets(0.1, 1).Build())
+	s.Get("/users/:id", func(ctx *context.Context) {
+		ctx.String(http.StatusOK, "ok")
+	})
+	s.Get("/error", func(ctx *context.Context) {
+		ctx.RespStatusCode = http.StatusBadGateway
+	})
+	// 直接写 Resp 的 handler
+	s.Get("/direct", func(ctx *context.Context) {
+		ctx.Resp.WriteHeader(http.StatusTeapot)
+		_, _ = ctx.Resp.Write([]byte("teapot"))
+	})
+	s.Get("/panic", func(ctx *context.Context) {
+		panic("boom")
+	})
+	s.Get("/metrics", reg.Handler())
+
+	for _, path := range []string{"/users/1", "/users/2", "/error", "/missing", "/direct", "/panic"} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/users/:id",status="2xx"} 2`,
+		`http_requests_total{method="GET",route="/error",status="5xx"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="4xx"} 1`,
+		`http_requests_total{method="GET",route="/direct",status="4xx"} 1`,
+		`http_requests_total{method="GET",route="/panic",status="5xx"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/users/:id",status="2xx"} 2`,
+		// 正在处理的就是 /metrics 这个请求本身
+		`http_requests_in_flight{method="GET"} 1`,
+	} {
+		assert.Contains(t, body, line)
+	}
+}
@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/session"
+)
+
+// KeyFunc 决定请求按照什么维度限流，返回空字符串表示这个请求不限流
+type KeyFunc func(ctx *context.Context) string
+
+// KeyByIP 按照客户端 IP 限流。经过反向代理的时候需要配置 ServerWithTrustedProxies
+func KeyByIP(ctx *context.Context) string {
+	return "ip:" + ctx.ClientIP()
+}
+
+// KeyBySession 按照 session 限流，拿不到 session 的请求按照 IP 限流
+func KeyBySession(m *session.Manager) KeyFunc {
+	return func(ctx *context.Context) string {
+		sess, err := m.GetSession(ctx)
+		if err != nil {
+			return KeyByIP(ctx)
+		}
+		return "session:" + sess.ID()
+	}
+}
+
+// KeyByAPIKey 按照请求头 header 里的 API key 限流，请求头为空的时候再看查询参数 query，
+// 两者都为空的请求按照 IP 限流。header 或者 query 为空字符串表示不从这里读
+func KeyByAPIKey(header, query string) KeyFunc {
+	return func(ctx *context.Context) string {
+		var key string
+		if header != "" {
+			key = ctx.Req.Header.Get(header)
+		}
+		if key == "" && query != "" {
+			key, _ = ctx.QueryValue(query).ToString()
+		}
+		if key == "" {
+			return KeyByIP(ctx)
+		}
+		return "apikey:" + key
+	}
+}
@@ -0,0 +1,167 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Result 是一次限流判断的结果
+type Result struct {
+	Allowed bool
+	// Limit 是配额的上限
+	Limit int
+	// Remaining 是这次请求之后剩余的配额
+	Remaining int
+	// Reset 是配额恢复还需要的时间
+	Reset time.Duration
+	// RetryAfter 是被拒绝之后至少需要等待多久再重试，只有 Allowed 为 false 的时候才有意义
+	RetryAfter time.Duration
+}
+
+type Limiter interface {
+	// Allow 消耗 key 的一个配额
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// TokenBucket 是令牌桶：每隔 period 补充 rate 个令牌，最多攒 burst 个，允许短时间的突发流量
+type TokenBucket struct {
+	store Store
+	burst int
+	// perNano 是每纳秒补充的令牌数
+	perNano float64
+	now     func() time.Time
+}
+
+func NewTokenBucket(store Store, rate int, period time.Duration, burst int) *TokenBucket {
+	if rate <= 0 || period <= 0 || burst <= 0 {
+		panic("ratelimit: rate、period 和 burst 都必须是正数")
+	}
+	return &TokenBucket{
+		store:   store,
+		burst:   burst,
+		perNano: float64(rate) / float64(period),
+		now:     time.Now,
+	}
+}
+
+func (t *TokenBucket) Allow(ctx context.Context, key string) (Result, error) {
+	now := t.now()
+	res := Result{Limit: t.burst}
+	// 桶满了之后状态就和新建的一样，可以淘汰
+	ttl := time.Duration(float64(t.burst) / t.perNano)
+	err := t.store.Update(ctx, "tb:"+key, ttl, func(state []byte) ([]byte, error) {
+		tokens, last := float64(t.burst), now
+		if len(state) == 16 {
+			tokens = math.Float64frombits(binary.BigEndian.Uint64(state))
+			last = time.Unix(0, int64(binary.BigEndian.Uint64(state[8:])))
+		}
+		if elapsed := now.Sub(last); elapsed > 0 {
+			tokens = math.Min(float64(t.burst), tokens+float64(elapsed)*t.perNano)
+		}
+		if tokens >= 1 {
+			tokens--
+			res.Allowed = true
+		} else {
+			res.RetryAfter = time.Duration(math.Ceil((1 - tokens) / t.perNano))
+		}
+		res.Remaining = int(tokens)
+		res.Reset = time.Duration(math.Ceil((float64(t.burst) - tokens) / t.perNano))
+
+		state = make([]byte, 16)
+		binary.BigEndian.PutUint64(state, math.Float64bits(tokens))
+		binary.BigEndian.PutUint64(state[8:], uint64(now.UnixNano()))
+		return state, nil
+	})
+	return res, err
+}
+
+// FixedWindow 是固定窗口计数：每个对齐的 window 里最多 limit 个请求。
+// 实现简单，但是在窗口的边界上最多可能放过 2*limit 个请求
+type FixedWindow struct {
+	store  Store
+	limit  int
+	window time.Duration
+	now    func() time.Time
+}
+
+func NewFixedWindow(store Store, limit int, window time.Duration) *FixedWindow {
+	if limit <= 0 || window <= 0 {
+		panic("ratelimit: limit 和 window 都必须是正数")
+	}
+	return &FixedWindow{store: store, limit: limit, window: window, now: time.Now}
+}
+
+func (f *FixedWindow) Allow(ctx context.Context, key string) (Result, error) {
+	now := f.now()
+	start := now.Truncate(f.window)
+	reset := start.Add(f.window).Sub(now)
+	res := Result{Limit: f.limit, Reset: reset}
+	err := f.store.Update(ctx, "fw:"+key, reset, func(state []byte) ([]byte, error) {
+		var count int64
+		if len(state) == 16 && int64(binary.BigEndian.Uint64(state)) == start.UnixNano() {
+			count = int64(binary.BigEndian.Uint64(state[8:]))
+		}
+		if count < int64(f.limit) {
+			count++
+			res.Allowed = true
+		} else {
+			res.RetryAfter = reset
+		}
+		res.Remaining = f.limit - int(count)
+
+		state = make([]byte, 16)
+		binary.BigEndian.PutUint64(state, uint64(start.UnixNano()))
+		binary.BigEndian.PutUint64(state[8:], uint64(count))
+		return state, nil
+	})
+	return res, err
+}
+
+// SlidingWindowLog 记录每个请求的时间，任意长度为 window 的时间段里最多 limit 个请求。
+// 它最精确，但是每个 key 需要保存 limit 个时间戳
+type SlidingWindowLog struct {
+	store  Store
+	limit  int
+	window time.Duration
+	now    func() time.Time
+}
+
+func NewSlidingWindowLog(store Store, limit int, window time.Duration) *SlidingWindowLog {
+	if limit <= 0 || window <= 0 {
+		panic("ratelimit: limit 和 window 都必须是正数")
+	}
+	return &SlidingWindowLog{store: store, limit: limit, window: window, now: time.Now}
+}
+
+func (s *SlidingWindowLog) Allow(ctx context.Context, key string) (Result, error) {
+	now := s.now()
+	res := Result{Limit: s.limit}
+	err := s.store.Update(ctx, "swl:"+key, s.window, func(state []byte) ([]byte, error) {
+		boundary := now.Add(-s.window).UnixNano()
+		logs := make([]int64, 0, s.limit)
+		for i := 0; i+8 <= len(state); i += 8 {
+			if ts := int64(binary.BigEndian.Uint64(state[i:])); ts > boundary {
+				logs = append(logs, ts)
+			}
+		}
+		if len(logs) < s.limit {
+			logs = append(logs, now.UnixNano())
+			res.Allowed = true
+		}
+		res.Remaining = s.limit - len(logs)
+		// 最早的一条记录滑出窗口之后就会空出一个配额
+		res.Reset = time.Unix(0, logs[0]).Add(s.window).Sub(now)
+		if !res.Allowed {
+			res.RetryAfter = res.Reset
+		}
+
+		state = make([]byte, 8*len(logs))
+		for i, ts := range logs {
+			binary.BigEndian.PutUint64(state[8*i:], uint64(ts))
+		}
+		return state, nil
+	})
+	return res, err
+}
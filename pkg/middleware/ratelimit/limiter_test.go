@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// fakeClock 让测试可以手动推进时间
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.now = f.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000040, 0)}
+}
+
+func TestTokenBucket_Allow(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryStore(WithCleanupInterval(0))
+	store.now = clock.Now
+	// 每秒补充 2 个，最多 3 个
+	l := NewTokenBucket(store, 2, time.Second, 3)
+	l.now = clock.Now
+
+	for i := 2; i >= 0; i-- {
+		res, err := l.Allow(context.Background(), "k")
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, i, res.Remaining)
+	}
+	res, err := l.Allow(context.Background(), "k")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, res.Reset)
+
+	// 其它 key 不受影响
+	res, _ = l.Allow(context.Background(), "other")
+	assert.True(t, res.Allowed)
+
+	clock.Advance(500 * time.Millisecond)
+	res, _ = l.Allow(context.Background(), "k")
+	assert.True(t, res.Allowed)
+	res, _ = l.Allow(context.Background(), "k")
+	assert.False(t, res.Allowed)
+}
+
+func TestFixedWindow_Allow(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryStore(WithCleanupInterval(0))
+	store.now = clock.Now
+	l := NewFixedWindow(store, 2, time.Minute)
+	l.now = clock.Now
+	clock.Advance(20 * time.Second)
+
+	res, _ := l.Allow(context.Background(), "k")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+	assert.Equal(t, 40*time.Second, res.Reset)
+	res, _ = l.Allow(context.Background(), "k")
+	assert.True(t, res.Allowed)
+	res, _ = l.Allow(context.Background(), "k")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, 40*time.Second, res.RetryAfter)
+
+	// 进入下一个窗口之后重新计数
+	clock.Advance(40 * time.Second)
+	res, _ = l.Allow(context.Background(), "k")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+}
+
+func TestSlidingWindowLog_Allow(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryStore(WithCleanupInterval(0))
+	store.now = clock.Now
+	l := NewSlidingWindowLog(store, 2, time.Minute)
+	l.now = clock.Now
+
+	res, _ := l.Allow(context.Background(), "k")
+	assert.True(t, res.Allowed)
+	clock.Advance(30 * time.Second)
+	res, _ = l.Allow(context.Background(), "k")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	res, _ = l.Allow(context.Background(), "k")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 30*time.Second, res.RetryAfter)
+
+	// 第一个请求滑出窗口，空出一个配额
+	clock.Advance(30 * time.Second)
+	res, _ = l.Allow(context.Background(), "k")
+	assert.True(t, res.Allowed)
+	res, _ = l.Allow(context.Background(), "k")
+	assert.False(t, res.Allowed)
+}
+
+func TestMemoryStore_Eviction(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryStore(WithMaxKeys(2), WithCleanupInterval(0))
+	store.now = clock.Now
+	set := func(key string, ttl time.Duration) {
+		require.NoError(t, store.Update(context.Background(), key, ttl, func(state []byte) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	}
+	get := func(key string) []byte {
+		var res []byte
+		require.NoError(t, store.Update(context.Background(), key, time.Minute, func(state []byte) ([]byte, error) {
+			res = state
+			return state, nil
+		}))
+		return res
+	}
+
+	set("a", time.Minute)
+	set("b", time.Second)
+	clock.Advance(2 * time.Second)
+	// 过期的 b 会被定期清理掉
+	store.removeExpired()
+	assert.Equal(t, 1, store.Len())
+
+	set("c", time.Minute)
+	set("d", time.Minute)
+	// 超过上限，最久没有更新的 a 被淘汰
+	assert.Equal(t, 2, store.Len())
+	assert.Nil(t, get("a"))
+	assert.Equal(t, []byte("d"), get("d"))
+}
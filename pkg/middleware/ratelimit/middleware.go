@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MiddlewareBuilder 构建限流中间件。
+// 通过 Server.Use 注册是全局限流，作为路由或者路由组的中间件注册就只作用在它们上面
+type MiddlewareBuilder struct {
+	limiter Limiter
+	keyFunc KeyFunc
+	name    string
+}
+
+// NewMiddlewareBuilder 默认按照客户端 IP 限流
+func NewMiddlewareBuilder(limiter Limiter) *MiddlewareBuilder {
+	return &MiddlewareBuilder{limiter: limiter, keyFunc: KeyByIP}
+}
+
+// KeyFunc 设置按照什么维度限流
+func (m *MiddlewareBuilder) KeyFunc(fn KeyFunc) *MiddlewareBuilder {
+	m.keyFunc = fn
+	return m
+}
+
+// Name 会作为 key 的前缀。多个中间件共用同一个 Limiter 或者 Store，
+// 但是需要各自计算配额的时候，给它们设置不同的名字
+func (m *MiddlewareBuilder) Name(name string) *MiddlewareBuilder {
+	m.name = name
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			key := m.keyFunc(ctx)
+			if key == "" {
+				next(ctx)
+				return
+			}
+			if m.name != "" {
+				key = m.name + ":" + key
+			}
+			res, err := m.limiter.Allow(ctx, key)
+			if err != nil {
+				// 限流的存储出问题的时候放行，不能因为它把整个服务拖垮
+				log.Println("ratelimit: 限流失败，放行请求", err)
+				next(ctx)
+				return
+			}
+			header := ctx.Resp.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			header.Set("RateLimit-Reset", seconds(res.Reset))
+			if !res.Allowed {
+				header.Set("Retry-After", seconds(res.RetryAfter))
+				ctx.AbortWithStatus(http.StatusTooManyRequests)
+				ctx.RespData = []byte(http.StatusText(http.StatusTooManyRequests))
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+// seconds 向上取整成秒，避免客户端提前重试
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
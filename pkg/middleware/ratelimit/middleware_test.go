@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	limiter := NewFixedWindow(store, 1, time.Hour)
+
+	s := sepweb.NewHttpServer()
+	api := s.Group("/api", NewMiddlewareBuilder(limiter).Name("api").
+		KeyFunc(KeyByAPIKey("X-API-Key", "api_key")).Build())
+	api.Get("/users", func(ctx *context.Context) {
+		ctx.String(http.StatusOK, "users")
+	})
+	s.Get("/login", func(ctx *context.Context) {
+		ctx.String(http.StatusOK, "login")
+	}, NewMiddlewareBuilder(limiter).Name("login").Build())
+	s.Get("/health", func(ctx *context.Context) {
+		ctx.String(http.StatusOK, "ok")
+	})
+
+	serve := func(path, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := serve("/api/users", "alice")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", recorder.Header().Get("RateLimit-Remaining"))
+	assert.NotEmpty(t, recorder.Header().Get("RateLimit-Reset"))
+
+	recorder = serve("/api/users", "alice")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.NotEmpty(t, recorder.Header().Get("Retry-After"))
+
+	// 不同的 API key 各自计算配额
+	assert.Equal(t, http.StatusOK, serve("/api/users?api_key=bob", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("/api/users?api_key=bob", "").Code)
+
+	// 不同名字的中间件共用 Limiter，但是配额互不影响
+	assert.Equal(t, http.StatusOK, serve("/login", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("/login", "").Code)
+
+	// 没有注册限流中间件的路由不受影响
+	assert.Equal(t, http.StatusOK, serve("/health", "").Code)
+	assert.Empty(t, serve("/health", "").Header().Get("RateLimit-Limit"))
+}
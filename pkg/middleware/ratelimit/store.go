@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/hashicorp/golang-lru/simplelru"
+	"sync"
+	"time"
+)
+
+// Store 保存限流器的状态。状态是限流算法自己编码的字节，
+// 所以外部的存储，比如 Redis，只需要支持按照 key 原子地读改写就可以实现它
+type Store interface {
+	// Update 原子地更新 key 对应的状态：fn 拿到当前的状态（不存在或者已经过期的时候是 nil），
+	// 返回新的状态。ttl 之后没有再更新过的状态可以被淘汰
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(state []byte) ([]byte, error)) error
+}
+
+// DefaultMaxKeys 是 MemoryStore 默认最多保存的 key 的数量
+const DefaultMaxKeys = 100000
+
+type MemoryStoreOption func(s *MemoryStore)
+
+// MemoryStore 是进程内的 Store。过期的状态会被定期清理，
+// key 的数量超过上限的时候淘汰最久没有更新过的
+type MemoryStore struct {
+	mu    sync.Mutex
+	items *simplelru.LRU
+	now   func() time.Time
+
+	cleanupInterval time.Duration
+	maxKeys         int
+	stop            chan struct{}
+	closeOnce       sync.Once
+}
+
+type memoryItem struct {
+	state    []byte
+	expireAt time.Time
+}
+
+func NewMemoryStore(opts ...MemoryStoreOption) *MemoryStore {
+	s := &MemoryStore{
+		now:             time.Now,
+		cleanupInterval: time.Minute,
+		maxKeys:         DefaultMaxKeys,
+		stop:            make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	// 只有 size 不是正数的时候才会出错
+	s.items, _ = simplelru.NewLRU(s.maxKeys, nil)
+	if s.cleanupInterval > 0 {
+		go s.cleanup()
+	}
+	return s
+}
+
+// WithMaxKeys 设置最多保存多少个 key
+func WithMaxKeys(n int) MemoryStoreOption {
+	return func(s *MemoryStore) {
+		s.maxKeys = n
+	}
+}
+
+// WithCleanupInterval 设置清理过期状态的间隔，0 表示不定期清理，只在访问的时候检查是否过期
+func WithCleanupInterval(interval time.Duration) MemoryStoreOption {
+	return func(s *MemoryStore) {
+		s.cleanupInterval = interval
+	}
+}
+
+func (s *MemoryStore) Update(ctx context.Context, key string, ttl time.Duration,
+	fn func(state []byte) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	var state []byte
+	if val, ok := s.items.Get(key); ok {
+		if item := val.(*memoryItem); now.Before(item.expireAt) {
+			state = item.state
+		}
+	}
+	state, err := fn(state)
+	if err != nil {
+		return err
+	}
+	s.items.Add(key, &memoryItem{state: state, expireAt: now.Add(ttl)})
+	return nil
+}
+
+// Len 返回当前保存的 key 的数量，包括还没有被清理的过期的 key
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.items.Len()
+}
+
+// Close 停止后台的清理
+func (s *MemoryStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	return nil
+}
+
+func (s *MemoryStore) cleanup() {
+	ticker := time.NewTicker(s.cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.removeExpired()
+		}
+	}
+}
+
+func (s *MemoryStore) removeExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for _, key := range s.items.Keys() {
+		if val, ok := s.items.Peek(key); ok && !now.Before(val.(*memoryItem).expireAt) {
+			s.items.Remove(key)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package recovery
+
+import (
+	"fmt"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Reporter 在 handler panic 的时候被调用，可以用来把错误上报给监控系统
+type Reporter func(ctx *context.Context, err any, stack []byte)
+
+// PanicError 包装在其它 goroutine 里捕获的 panic，带上它原本的调用栈，
+// 比如 timeout 中间件把 handler 放到单独的 goroutine 里执行，再在请求的 goroutine 里重新 panic
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("%v", p.Value)
+}
+
+// Unwrap 在 Value 是 error 的时候返回它
+func (p *PanicError) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
+
+// MiddlewareBuilder 把 handler 的 panic 转换成 500 响应。
+// 要让 500 经过 errhdl 处理，errhdl 的中间件需要注册在它的前面
+type MiddlewareBuilder struct {
+	reporter Reporter
+}
+
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		reporter: func(ctx *context.Context, err any, stack []byte) {
+			log.Printf("web: %s %s panic: %v\n%s", ctx.Req.Method, ctx.Req.URL.Path, err, stack)
+		},
+	}
+}
+
+func (m *MiddlewareBuilder) Reporter(reporter Reporter) *MiddlewareBuilder {
+	m.reporter = reporter
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				// 这是 net/http 约定的主动中断连接的方式，要交还给 http.Server 处理
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				stack := debug.Stack()
+				if pe, ok := err.(*PanicError); ok {
+					err, stack = pe.Value, pe.Stack
+				}
+				m.reporter(ctx, err, stack)
+				// 响应已经写出去了，没法再改成 500
+				if ctx.Streamed() || ctx.Hijacked() {
+					return
+				}
+				ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("web: panic: %v", err))
+				ctx.RespData = []byte(http.StatusText(http.StatusInternalServerError))
+			}()
+			next(ctx)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package recovery
+
+import (
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/middleware/errhdl"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	var reported any
+	var stack []byte
+	s := sepweb.NewHttpServer()
+	s.Use(errhdl.NewMiddlewareBuilder().
+		RegisterError(http.StatusInternalServerError, []byte("oops")).Build())
+	s.Use(NewMiddlewareBuilder().Reporter(func(ctx *context.Context, err any, st []byte) {
+		reported = err
+		stack = st
+	}).Build())
+	s.Get("/panic", func(ctx *context.Context) {
+		panic("something wrong")
+	})
+	s.Get("/stream", func(ctx *context.Context) {
+		_ = ctx.Stream("text/plain", func(w io.Writer) error {
+			_, _ = w.Write([]byte("partial"))
+			panic("broken stream")
+		})
+	})
+	s.Get("/abort", func(ctx *context.Context) {
+		panic(http.ErrAbortHandler)
+	})
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, "oops", recorder.Body.String())
+	assert.Equal(t, "something wrong", reported)
+	assert.True(t, strings.Contains(string(stack), "recovery"))
+
+	// 已经写出去的流式响应没法再改
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "partial", recorder.Body.String())
+	assert.Equal(t, "broken stream", reported)
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abort", nil))
+	})
+}
@@ -0,0 +1,100 @@
+package requestid
+
+import (
+	stdctx "context"
+	"github.com/google/uuid"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"net/http"
+)
+
+const DefaultHeader = "X-Request-ID"
+
+// 客户端传上来的 ID 太长就不用了，避免被用来往日志里塞垃圾数据
+const maxIDLength = 128
+
+var key = context.NewKey[string]("request-id")
+
+type MiddlewareBuilder struct {
+	header    string
+	generator func() string
+}
+
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		header:    DefaultHeader,
+		generator: uuid.NewString,
+	}
+}
+
+// Header 设置读取和回写请求 ID 的头部
+func (m *MiddlewareBuilder) Header(name string) *MiddlewareBuilder {
+	m.header = name
+	return m
+}
+
+// Generator 设置请求里没有带 ID 的时候，生成新 ID 的方法
+func (m *MiddlewareBuilder) Generator(fn func() string) *MiddlewareBuilder {
+	m.generator = fn
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			id := ctx.Req.Header.Get(m.header)
+			if !valid(id) {
+				id = m.generator()
+			}
+			context.Set(ctx, key, id)
+			// 同时放到请求的 context 里，下游只拿到 ctx.Req.Context() 也能取到
+			ctx.Req = ctx.Req.WithContext(stdctx.WithValue(ctx.Req.Context(), key, id))
+			ctx.Resp.Header().Set(m.header, id)
+			next(ctx)
+		}
+	}
+}
+
+// FromContext 取出请求 ID，ctx 可以是 *context.Context，也可以是请求的 context
+func FromContext(ctx stdctx.Context) (string, bool) {
+	id, ok := ctx.Value(key).(string)
+	return id, ok
+}
+
+// Transport 把请求 context 里的 ID 带到发往下游服务的请求上
+type Transport struct {
+	// Base 为 nil 的时候使用 http.DefaultTransport
+	Base http.RoundTripper
+	// Header 为空的时候使用 DefaultHeader
+	Header string
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	header := t.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+	if id, ok := FromContext(req.Context()); ok && req.Header.Get(header) == "" {
+		// RoundTripper 不应该修改传进来的请求
+		req = req.Clone(req.Context())
+		req.Header.Set(header, id)
+	}
+	return base.RoundTrip(req)
+}
+
+func valid(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package requestid
+
+import (
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	testCases := []struct {
+		name    string
+		builder *MiddlewareBuilder
+		header  string
+		reqID   string
+		wantID  string
+	}{
+		{
+			name:    "reuse incoming id",
+			builder: NewMiddlewareBuilder(),
+			header:  DefaultHeader,
+			reqID:   "abc-123",
+			wantID:  "abc-123",
+		},
+		{
+			name:    "generate id",
+			builder: NewMiddlewareBuilder().Generator(func() string { return "generated" }),
+			header:  DefaultHeader,
+			wantID:  "generated",
+		},
+		{
+			name:    "reject invalid id",
+			builder: NewMiddlewareBuilder().Generator(func() string { return "generated" }),
+			header:  DefaultHeader,
+			reqID:   strings.Repeat("a", 200),
+			wantID:  "generated",
+		},
+		{
+			name:    "custom header",
+			builder: NewMiddlewareBuilder().Header("X-Trace-ID"),
+			header:  "X-Trace-ID",
+			reqID:   "trace-1",
+			wantID:  "trace-1",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := sepweb.NewHttpServer()
+			s.Use(tc.builder.Build())
+			s.Get("/", func(ctx *context.Context) {
+				id, ok := FromContext(ctx)
+				require.True(t, ok)
+				reqID, ok := FromContext(ctx.Req.Context())
+				require.True(t, ok)
+				assert.Equal(t, id, reqID)
+				ctx.RespData = []byte(id)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.reqID != "" {
+				req.Header.Set(tc.header, tc.reqID)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantID, recorder.Body.String())
+			assert.Equal(t, tc.wantID, recorder.Header().Get(tc.header))
+		})
+	}
+}
+
+func TestTransport(t *testing.T) {
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get(DefaultHeader)))
+	}))
+	defer downstream.Close()
+	client := &http.Client{Transport: &Transport{}}
+
+	s := sepweb.NewHttpServer()
+	s.Use(NewMiddlewareBuilder().Build())
+	s.Get("/", func(ctx *context.Context) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, downstream.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		ctx.RespData, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(DefaultHeader, "abc-123")
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, "abc-123", recorder.Body.String())
+}
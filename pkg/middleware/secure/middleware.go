@@ -0,0 +1,176 @@
+package secure
+
+import (
+	stdctx "context"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"github.com/igevin/sepweb/pkg/template"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NoncePlaceholder 在 CSP 里会被替换成 'nonce-xxx'，每个请求的 nonce 都不一样
+const NoncePlaceholder = "{nonce}"
+
+var nonceKey = context.NewKey[string]("csp-nonce")
+
+type MiddlewareBuilder struct {
+	hsts          string
+	csp           string
+	cspReportOnly bool
+	// headers 是除了 HSTS 和 CSP 之外固定的响应头，值为空的不设置
+	headers map[string]string
+}
+
+// NewMiddlewareBuilder 默认设置：
+// HSTS 一年并且包含子域名、X-Content-Type-Options: nosniff、X-Frame-Options: DENY、
+// Referrer-Policy: strict-origin-when-cross-origin、Cross-Origin-Opener-Policy: same-origin。
+// CSP、Permissions-Policy 和 Cross-Origin-Embedder-Policy 和应用关系太大，默认不设置
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	m := &MiddlewareBuilder{
+		headers: map[string]string{
+			"X-Content-Type-Options":     "nosniff",
+			"X-Frame-Options":            "DENY",
+			"Referrer-Policy":            "strict-origin-when-cross-origin",
+			"Cross-Origin-Opener-Policy": "same-origin",
+		},
+	}
+	return m.HSTS(365*24*time.Hour, true, false)
+}
+
+// HSTS 设置 Strict-Transport-Security，maxAge 为 0 表示不设置。
+// 浏览器会忽略通过 HTTP 收到的 HSTS，所以不区分请求是不是 HTTPS
+func (m *MiddlewareBuilder) HSTS(maxAge time.Duration, includeSubDomains, preload bool) *MiddlewareBuilder {
+	if maxAge <= 0 {
+		m.hsts = ""
+		return m
+	}
+	hsts := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	if includeSubDomains {
+		hsts += "; includeSubDomains"
+	}
+	if preload {
+		hsts += "; preload"
+	}
+	m.hsts = hsts
+	return m
+}
+
+// ContentSecurityPolicy 设置 CSP，policy 里的 NoncePlaceholder 会替换成这个请求的 nonce，比如
+// "script-src 'self' {nonce}"。模板里通过 cspNonce 函数拿到同一个 nonce
+func (m *MiddlewareBuilder) ContentSecurityPolicy(policy string) *MiddlewareBuilder {
+	m.csp = policy
+	return m
+}
+
+// ReportOnly 让 CSP 只上报不拦截，一般在上线新的策略之前先观察一段时间
+func (m *MiddlewareBuilder) ReportOnly() *MiddlewareBuilder {
+	m.cspReportOnly = true
+	return m
+}
+
+// ContentTypeNosniff 设置为 false 的时候不设置 X-Content-Type-Options
+func (m *MiddlewareBuilder) ContentTypeNosniff(enabled bool) *MiddlewareBuilder {
+	if enabled {
+		return m.header("X-Content-Type-Options", "nosniff")
+	}
+	return m.header("X-Content-Type-Options", "")
+}
+
+// FrameOptions 设置 X-Frame-Options，比如 DENY、SAMEORIGIN，空字符串表示不设置
+func (m *MiddlewareBuilder) FrameOptions(val string) *MiddlewareBuilder {
+	return m.header("X-Frame-Options", val)
+}
+
+// ReferrerPolicy 设置 Referrer-Policy，空字符串表示不设置
+func (m *MiddlewareBuilder) ReferrerPolicy(val string) *MiddlewareBuilder {
+	return m.header("Referrer-Policy", val)
+}
+
+// PermissionsPolicy 设置 Permissions-Policy，比如 "camera=(), geolocation=(self)"
+func (m *MiddlewareBuilder) PermissionsPolicy(val string) *MiddlewareBuilder {
+	return m.header("Permissions-Policy", val)
+}
+
+// CrossOriginOpenerPolicy 设置 Cross-Origin-Opener-Policy，空字符串表示不设置
+func (m *MiddlewareBuilder) CrossOriginOpenerPolicy(val string) *MiddlewareBuilder {
+	return m.header("Cross-Origin-Opener-Policy", val)
+}
+
+// CrossOriginEmbedderPolicy 设置 Cross-Origin-Embedder-Policy，比如 require-corp。
+// 开启之后跨域的资源都要显式允许才能加载
+func (m *MiddlewareBuilder) CrossOriginEmbedderPolicy(val string) *MiddlewareBuilder {
+	return m.header("Cross-Origin-Embedder-Policy", val)
+}
+
+func (m *MiddlewareBuilder) header(name, val string) *MiddlewareBuilder {
+	m.headers[name] = val
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	cspHeader := "Content-Security-Policy"
+	if m.cspReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	useNonce := strings.Contains(m.csp, NoncePlaceholder)
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			// 在 next 之前设置，出错的响应也能带上，handler 也可以覆盖
+			header := ctx.Resp.Header()
+			for name, val := range m.headers {
+				if val != "" {
+					header.Set(name, val)
+				}
+			}
+			if m.hsts != "" {
+				header.Set("Strict-Transport-Security", m.hsts)
+			}
+			if m.csp != "" {
+				csp := m.csp
+				if useNonce {
+					nonce, err := newNonce()
+					if err != nil {
+						// 没有 nonce 的话 nonce 相关的脚本都不能执行，但是不影响其它的限制
+						log.Println("secure: 生成 nonce 失败", err)
+					} else {
+						context.Set(ctx, nonceKey, nonce)
+						csp = strings.ReplaceAll(csp, NoncePlaceholder, "'nonce-"+nonce+"'")
+					}
+				}
+				header.Set(cspHeader, csp)
+			}
+			next(ctx)
+		}
+	}
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	// 用 base64url，放进 HTML 属性里不会被模板转义
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Nonce 返回当前请求的 CSP nonce，没有配置 nonce 的时候返回空字符串
+func Nonce(ctx stdctx.Context) string {
+	nonce, _ := ctx.Value(nonceKey).(string)
+	return nonce
+}
+
+// TemplateFuncs 返回 cspNonce 模板函数，用法是 <script nonce="{{cspNonce}}">。
+// 需要在加载模板之前通过 GoTemplateEngine.ContextFuncs 注册
+func TemplateFuncs() map[string]template.ContextFunc {
+	return map[string]template.ContextFunc{
+		"cspNonce": func(ctx stdctx.Context) any {
+			return Nonce(ctx)
+		},
+	}
+}
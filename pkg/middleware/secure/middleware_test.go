@@ -0,0 +1,95 @@
+package secure
+
+import (
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	engine := &template.GoTemplateEngine{}
+	engine.ContextFuncs(TemplateFuncs())
+	require.NoError(t, engine.LoadFromFS(fstest.MapFS{
+		"page.gohtml": {Data: []byte(`<script nonce="{{ cspNonce }}"></script>`)},
+	}, "*.gohtml"))
+
+	render := func(ctx *context.Context) {
+		_ = ctx.Render("page.gohtml", nil)
+	}
+
+	// 默认配置
+	s := sepweb.NewHttpServer()
+	s.Use(NewMiddlewareBuilder().Build())
+	s.Get("/", func(ctx *context.Context) {
+		ctx.String(http.StatusOK, "ok")
+	})
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.Header{
+		"Strict-Transport-Security":  {"max-age=31536000; includeSubDomains"},
+		"X-Content-Type-Options":     {"nosniff"},
+		"X-Frame-Options":            {"DENY"},
+		"Referrer-Policy":            {"strict-origin-when-cross-origin"},
+		"Cross-Origin-Opener-Policy": {"same-origin"},
+		"Content-Type":               {"text/plain; charset=utf-8"},
+	}, recorder.Header())
+
+	// 找不到路由的响应也带上
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, "DENY", recorder.Header().Get("X-Frame-Options"))
+
+	// 自定义配置和 nonce
+	s = sepweb.NewHttpServer(sepweb.ServerWithTemplateEngine(engine))
+	s.Use(NewMiddlewareBuilder().
+		HSTS(time.Hour, false, true).
+		ContentTypeNosniff(false).
+		FrameOptions("SAMEORIGIN").
+		ReferrerPolicy("").
+		PermissionsPolicy("camera=(), geolocation=(self)").
+		CrossOriginOpenerPolicy("same-origin-allow-popups").
+		CrossOriginEmbedderPolicy("require-corp").
+		ContentSecurityPolicy("default-src 'self'; script-src 'self' " + NoncePlaceholder).Build())
+	s.Get("/", render)
+	nonceRe := regexp.MustCompile(`^default-src 'self'; script-src 'self' 'nonce-([A-Za-z0-9_-]{22})'$`)
+	var nonces []string
+	for i := 0; i < 2; i++ {
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		header := recorder.Header()
+		assert.Equal(t, "max-age=3600; preload", header.Get("Strict-Transport-Security"))
+		assert.Equal(t, "", header.Get("X-Content-Type-Options"))
+		assert.Equal(t, "SAMEORIGIN", header.Get("X-Frame-Options"))
+		assert.Equal(t, "", header.Get("Referrer-Policy"))
+		assert.Equal(t, "camera=(), geolocation=(self)", header.Get("Permissions-Policy"))
+		assert.Equal(t, "same-origin-allow-popups", header.Get("Cross-Origin-Opener-Policy"))
+		assert.Equal(t, "require-corp", header.Get("Cross-Origin-Embedder-Policy"))
+		match := nonceRe.FindStringSubmatch(header.Get("Content-Security-Policy"))
+		require.Len(t, match, 2)
+		// 模板里的 nonce 和响应头里的一致
+		assert.Equal(t, `<script nonce="`+match[1]+`"></script>`, recorder.Body.String())
+		nonces = append(nonces, match[1])
+	}
+	assert.NotEqual(t, nonces[0], nonces[1])
+
+	// 只上报不拦截，没有 nonce 占位符的时候模板里拿到空字符串
+	s = sepweb.NewHttpServer(sepweb.ServerWithTemplateEngine(engine))
+	s.Use(NewMiddlewareBuilder().HSTS(0, true, true).
+		ContentSecurityPolicy("default-src 'self'; report-uri /csp").ReportOnly().Build())
+	s.Get("/", render)
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "", recorder.Header().Get("Strict-Transport-Security"))
+	assert.Equal(t, "", recorder.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "default-src 'self'; report-uri /csp", recorder.Header().Get("Content-Security-Policy-Report-Only"))
+	assert.Equal(t, `<script nonce=""></script>`, recorder.Body.String())
+}
@@ -0,0 +1,134 @@
+package timeout
+
+import (
+	"bytes"
+	stdctx "context"
+	"errors"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"github.com/igevin/sepweb/pkg/middleware/recovery"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// ErrTimeout 是超时之后通过 Context.AbortError 拿到的错误
+var ErrTimeout = errors.New("timeout: 请求处理超时")
+
+// MiddlewareBuilder 限制 handler 的处理时间。
+// handler 在 Context 的副本上执行，直接写回的响应（比如 Stream）会先缓冲起来，
+// 按时完成之后才写给客户端，超时之后它再写什么都不会影响到真正的响应。
+// 所以它不适合流式响应，也不支持升级成 WebSocket
+type MiddlewareBuilder struct {
+	timeout time.Duration
+	code    int
+	body    []byte
+}
+
+func NewMiddlewareBuilder(timeout time.Duration) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		timeout: timeout,
+		code:    http.StatusServiceUnavailable,
+		body:    []byte(http.StatusText(http.StatusServiceUnavailable)),
+	}
+}
+
+// Response 设置超时的响应，默认是 503。作为网关转发请求的时候一般用 504
+func (m *MiddlewareBuilder) Response(code int, body []byte) *MiddlewareBuilder {
+	m.code = code
+	m.body = body
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			reqCtx, cancel := stdctx.WithTimeout(ctx.Req.Context(), m.timeout)
+			defer cancel()
+			ctx.Req = ctx.Req.WithContext(reqCtx)
+
+			bw := &bufferedWriter{header: ctx.Resp.Header().Clone()}
+			cp := ctx.Copy()
+			cp.Resp = bw
+			done := make(chan struct{})
+			panicChan := make(chan any, 1)
+			go func() {
+				defer func() {
+					if err := recover(); err != nil {
+						if err == http.ErrAbortHandler {
+							panicChan <- err
+							return
+						}
+						// 在请求的 goroutine 里重新 panic 会丢掉原本的调用栈，所以在这里记下来
+						panicChan <- &recovery.PanicError{Value: err, Stack: debug.Stack()}
+					}
+				}()
+				next(cp)
+				close(done)
+			}()
+
+			select {
+			case err := <-panicChan:
+				// 交给外层的 recovery 处理
+				panic(err)
+			case <-done:
+				ctx.Restore(cp)
+				bw.writeTo(ctx.Resp)
+			case <-reqCtx.Done():
+				if errors.Is(reqCtx.Err(), stdctx.DeadlineExceeded) {
+					ctx.AbortWithError(m.code, ErrTimeout)
+					ctx.RespData = m.body
+					return
+				}
+				// 客户端已经断开了，响应没有人看
+				ctx.AbortWithError(http.StatusServiceUnavailable, reqCtx.Err())
+			}
+		}
+	}
+}
+
+// bufferedWriter 缓存 handler 直接写回的响应
+type bufferedWriter struct {
+	header      http.Header
+	buf         bytes.Buffer
+	code        int
+	wroteHeader bool
+}
+
+func (b *bufferedWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedWriter) WriteHeader(code int) {
+	if b.wroteHeader {
+		return
+	}
+	b.wroteHeader = true
+	b.code = code
+}
+
+func (b *bufferedWriter) Write(data []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.buf.Write(data)
+}
+
+// Flush 什么也不做，超时之前不能把任何数据写给客户端
+func (b *bufferedWriter) Flush() {}
+
+// writeTo 把 handler 设置的头部同步回去，handler 直接写过响应的话一并写回
+func (b *bufferedWriter) writeTo(w http.ResponseWriter) {
+	header := w.Header()
+	for k := range header {
+		if _, ok := b.header[k]; !ok {
+			delete(header, k)
+		}
+	}
+	for k, v := range b.header {
+		header[k] = v
+	}
+	if b.wroteHeader {
+		w.WriteHeader(b.code)
+		_, _ = w.Write(b.buf.Bytes())
+	}
+}
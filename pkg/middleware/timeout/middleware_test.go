@@ -0,0 +1,100 @@
+package timeout
+
+import (
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware/recovery"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	var abortErr error
+	finished := make(chan struct{})
+	s := sepweb.NewHttpServer()
+	s.Use(func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			next(ctx)
+			abortErr = ctx.AbortError()
+		}
+	})
+	s.Use(NewMiddlewareBuilder(50 * time.Millisecond).Build())
+	s.Get("/fast", func(ctx *context.Context) {
+		_, ok := ctx.Deadline()
+		assert.True(t, ok)
+		ctx.Resp.Header().Set("X-Handler", "fast")
+		ctx.String(http.StatusCreated, "fast")
+	})
+	s.Get("/slow", func(ctx *context.Context) {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+		// 超时之后再写也不会影响真正的响应
+		ctx.Resp.Header().Set("X-Handler", "slow")
+		ctx.String(http.StatusOK, "slow")
+		close(finished)
+	})
+	s.Get("/stream", func(ctx *context.Context) {
+		_ = ctx.Stream("text/plain", func(w io.Writer) error {
+			_, err := io.WriteString(w, "streamed")
+			return err
+		})
+	})
+	s.Get("/gateway", func(ctx *context.Context) {
+		<-ctx.Done()
+	}, NewMiddlewareBuilder(10*time.Millisecond).Response(http.StatusGatewayTimeout, nil).Build())
+	s.Get("/panic", panicHandler)
+	var reported any
+	var stack []byte
+	s.Get("/recovered", panicHandler, recovery.NewMiddlewareBuilder().
+		Reporter(func(ctx *context.Context, err any, st []byte) {
+			reported, stack = err, st
+		}).Build(), NewMiddlewareBuilder(time.Second).Build())
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	assert.Equal(t, "fast", recorder.Body.String())
+	assert.Equal(t, "fast", recorder.Header().Get("X-Handler"))
+	assert.Nil(t, abortErr)
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	<-finished
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "Service Unavailable", recorder.Body.String())
+	assert.Empty(t, recorder.Header().Get("X-Handler"))
+	assert.Equal(t, ErrTimeout, abortErr)
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "streamed", recorder.Body.String())
+	assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"))
+
+	// 路由上更短的超时先生效
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/gateway", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+
+	assert.PanicsWithError(t, "boom", func() {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+	})
+
+	// recovery 拿到的是 handler 所在的 goroutine 的调用栈
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/recovered", nil))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, "boom", reported)
+	assert.Contains(t, string(stack), "timeout.panicHandler")
+}
+
+func panicHandler(ctx *context.Context) {
+	panic("boom")
+}
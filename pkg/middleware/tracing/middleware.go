@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"fmt"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"github.com/igevin/sepweb/pkg/trace"
+	"net/http"
+)
+
+type MiddlewareBuilder struct {
+	tracer *trace.Tracer
+}
+
+func NewMiddlewareBuilder(tracer *trace.Tracer) *MiddlewareBuilder {
+	return &MiddlewareBuilder{tracer: tracer}
+}
+
+func (m *MiddlewareBuilder) Build() middleware.Middleware {
+	return func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			reqCtx := ctx.Req.Context()
+			if sc, ok := trace.Extract(ctx.Req.Header); ok {
+				reqCtx = trace.ContextWithRemoteSpanContext(reqCtx, sc)
+			}
+			// 这个时候还没有匹配路由，先用方法名占位，结束的时候再改成路由
+			reqCtx, span := m.tracer.Start(reqCtx, "HTTP "+ctx.Req.Method)
+			ctx.Req = ctx.Req.WithContext(reqCtx)
+			span.SetAttribute("http.method", ctx.Req.Method)
+			span.SetAttribute("http.target", ctx.Req.URL.RequestURI())
+
+			defer func() {
+				if err := recover(); err != nil {
+					span.RecordError(fmt.Errorf("panic: %v", err))
+					m.end(ctx, span)
+					panic(err)
+				}
+			}()
+			next(ctx)
+			m.end(ctx, span)
+		}
+	}
+}
+
+func (m *MiddlewareBuilder) end(ctx *context.Context, span *trace.Span) {
+	if ctx.MatchedRoute != "" {
+		span.SetName(ctx.MatchedRoute)
+		span.SetAttribute("http.route", ctx.MatchedRoute)
+	}
+	code := ctx.RespStatusCode
+	if code == 0 {
+		code = http.StatusOK
+	}
+	span.SetAttribute("http.status_code", code)
+	if err := ctx.AbortError(); err != nil {
+		span.RecordError(err)
+	} else if code >= http.StatusInternalServerError {
+		span.SetStatus(trace.StatusError, http.StatusText(code))
+	}
+	span.End()
+}
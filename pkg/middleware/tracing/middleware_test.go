@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"errors"
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	exporter := trace.NewInMemoryExporter()
+	tracer := trace.NewTracer(exporter)
+	s := sepweb.NewHttpServer()
+	s.Use(NewMiddlewareBuilder(tracer).Build())
+	s.Get("/users/:id", func(ctx *context.Context) {
+		_, span := tracer.Start(ctx, "load user")
+		span.End()
+		ctx.String(http.StatusOK, "ok")
+	})
+	s.Get("/fail", func(ctx *context.Context) {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("db down"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set(trace.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	child, root := spans[0], spans[1]
+	assert.Equal(t, "load user", child.Name)
+	assert.Equal(t, root.SpanID, child.ParentSpanID)
+	assert.Equal(t, "/users/:id", root.Name)
+	assert.Equal(t, "00f067aa0ba902b7", root.ParentSpanID)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", root.TraceID)
+	assert.Equal(t, http.StatusOK, root.Attributes["http.status_code"])
+	assert.Equal(t, trace.StatusUnset, root.Status)
+
+	exporter.Reset()
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+	spans = exporter.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "/fail", spans[0].Name)
+	assert.Empty(t, spans[0].ParentSpanID)
+	assert.Equal(t, trace.StatusError, spans[0].Status)
+	assert.Equal(t, "db down", spans[0].StatusDescription)
+	require.Len(t, spans[0].Events, 1)
+	assert.Equal(t, "exception", spans[0].Events[0].Name)
+}
@@ -3,21 +3,84 @@ package route
 import (
 	"fmt"
 	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"net/url"
 	"strings"
 )
 
 type Router struct {
 	routes map[string]*node
+	// names 记录路由的名字和对应的路径，用来反向生成 URL
+	names map[string]string
 }
 
 func NewRouter() Router {
 	return Router{
 		routes: map[string]*node{},
+		names:  map[string]string{},
 	}
 }
 
-func (r *Router) AddRoute(method, path string, handler handler.Handle) {
+// AddNamedRoute 注册一条带名字的路由，之后可以通过 URL 根据名字生成路径
+func (r *Router) AddNamedRoute(name, method, path string, handler handler.Handle, mdls ...middleware.Middleware) {
+	if old, ok := r.names[name]; ok && old != path {
+		panic(fmt.Sprintf("web: 路由名字冲突，%s 已经对应 %s", name, old))
+	}
+	r.AddRoute(method, path, handler, mdls...)
+	r.names[name] = path
+}
+
+// URL 根据路由的名字生成路径，params 用来填充路径参数，通配符对应的参数名是 *
+func (r *Router) URL(name string, params map[string]string) (string, error) {
+	path, ok := r.names[name]
+	if !ok {
+		return "", fmt.Errorf("web: 找不到名字为 %s 的路由", name)
+	}
+	if path == "/" {
+		return path, nil
+	}
+	var sb strings.Builder
+	for _, seg := range strings.Split(path[1:], "/") {
+		sb.WriteByte('/')
+		key, isParam := "", false
+		switch {
+		case seg == "*":
+			key, isParam = seg, true
+		case seg[0] == ':':
+			key, isParam = seg[1:], true
+		}
+		if !isParam {
+			sb.WriteString(seg)
+			continue
+		}
+		paramName, regExpr := (&node{}).matchAndParseRegExp(seg)
+		if regExpr != nil {
+			key = paramName
+		}
+		val, ok := params[key]
+		if !ok {
+			return "", fmt.Errorf("web: 生成 %s 的 URL 缺少路径参数 %s", name, key)
+		}
+		if regExpr != nil && !regExpr.MatchString(val) {
+			return "", fmt.Errorf("web: 路径参数 %s 的值 %s 不满足 %s", key, val, regExpr.String())
+		}
+		if key == "*" {
+			// 通配符可以匹配多段路径，不转义 /
+			sb.WriteString(val)
+			continue
+		}
+		sb.WriteString(url.PathEscape(val))
+	}
+	return sb.String(), nil
+}
+
+// AddRoute 注册路由，mdls 是只作用在这条路由上的中间件，
+// 它们在路由匹配之后、handler 之前执行
+func (r *Router) AddRoute(method, path string, handler handler.Handle, mdls ...middleware.Middleware) {
 	_ = r.checkPathFormat(path)
+	if len(mdls) > 0 {
+		handler = middleware.Chain(handler, mdls...)
+	}
 	root, ok := r.handleRootRouter(method, path, handler)
 	if ok {
 		return
@@ -599,3 +599,75 @@ func Test_router_findRoute(t *testing.T) {
 		})
 	}
 }
+
+func Test_router_URL(t *testing.T) {
+	mockHandler := func(ctx *context.Context) {}
+	r := NewRouter()
+	r.AddNamedRoute("root", http.MethodGet, "/", mockHandler)
+	r.AddNamedRoute("user", http.MethodGet, "/user/:id/detail", mockHandler)
+	r.AddNamedRoute("order", http.MethodGet, "/order/:id([0-9]+)", mockHandler)
+	r.AddNamedRoute("static", http.MethodGet, "/static/*", mockHandler)
+
+	testCases := []struct {
+		name      string
+		routeName string
+		params    map[string]string
+		wantURL   string
+		wantErr   bool
+	}{
+		{
+			name:      "root",
+			routeName: "root",
+			wantURL:   "/",
+		},
+		{
+			name:      "param",
+			routeName: "user",
+			params:    map[string]string{"id": "Tom Cat"},
+			wantURL:   "/user/Tom%20Cat/detail",
+		},
+		{
+			name:      "missing param",
+			routeName: "user",
+			wantErr:   true,
+		},
+		{
+			name:      "regexp",
+			routeName: "order",
+			params:    map[string]string{"id": "123"},
+			wantURL:   "/order/123",
+		},
+		{
+			name:      "regexp mismatch",
+			routeName: "order",
+			params:    map[string]string{"id": "abc"},
+			wantErr:   true,
+		},
+		{
+			name:      "star",
+			routeName: "static",
+			params:    map[string]string{"*": "css/app.css"},
+			wantURL:   "/static/css/app.css",
+		},
+		{
+			name:      "unknown",
+			routeName: "unknown",
+			wantErr:   true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			url, err := r.URL(tc.routeName, tc.params)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantURL, url)
+		})
+	}
+
+	assert.Panics(t, func() {
+		r.AddNamedRoute("user", http.MethodPost, "/user", mockHandler)
+	})
+}
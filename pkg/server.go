@@ -3,11 +3,15 @@ package sepweb
 import (
 	"github.com/igevin/sepweb/pkg/context"
 	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/keyring"
 	"github.com/igevin/sepweb/pkg/middleware"
 	"github.com/igevin/sepweb/pkg/route"
 	"github.com/igevin/sepweb/pkg/template"
 	"log"
 	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
 )
 
 type Server interface {
@@ -19,6 +23,19 @@ type HttpServer struct {
 	route.Router
 	mdls      []middleware.Middleware
 	tplEngine template.TemplateEngine
+	keyring   *keyring.Keyring
+
+	// handler 是组装好的整条处理链路，在第一个请求到来的时候构建一次，
+	// 之后路由和中间件都不能再修改
+	handler   handler.Handle
+	buildOnce sync.Once
+	frozen    atomic.Bool
+
+	ctxPool        sync.Pool
+	disableCtxPool bool
+
+	maxBodySize    int64
+	trustedProxies []netip.Prefix
 }
 
 type ServerOption func(server *HttpServer)
@@ -28,21 +45,44 @@ func (s *HttpServer) Start(addr string) error {
 }
 
 func (s *HttpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ctx := &context.Context{
-		Req:       r,
-		Resp:      w,
-		TplEngine: s.tplEngine,
+	s.buildOnce.Do(s.buildHandler)
+	ctx := s.acquireContext(w, r)
+	s.handler(ctx)
+	s.releaseContext(ctx)
+}
+
+func (s *HttpServer) buildHandler() {
+	handle := middleware.Chain(s.serve, s.mdls...)
+	s.handler = s.flushRespMiddleware(handle)
+	s.frozen.Store(true)
+}
+
+func (s *HttpServer) acquireContext(w http.ResponseWriter, r *http.Request) *context.Context {
+	var ctx *context.Context
+	if s.disableCtxPool {
+		ctx = &context.Context{}
+	} else {
+		ctx = s.ctxPool.Get().(*context.Context)
 	}
-	handle := s.serve
-	for i := len(s.mdls) - 1; i >= 0; i-- {
-		handle = s.mdls[i](handle)
+	ctx.Reset(w, r)
+	ctx.TplEngine = s.tplEngine
+	ctx.Keyring = s.keyring
+	ctx.Routes = &s.Router
+	ctx.TrustedProxies = s.trustedProxies
+	if s.maxBodySize > 0 {
+		ctx.LimitBody(s.maxBodySize)
 	}
+	return ctx
+}
 
-	handle = s.flushRespMiddleware(handle)
-	handle(ctx)
+func (s *HttpServer) releaseContext(ctx *context.Context) {
+	if ctx.Release() && !s.disableCtxPool {
+		s.ctxPool.Put(ctx)
+	}
 }
 
 func (s *HttpServer) Use(mdls ...middleware.Middleware) {
+	s.checkFrozen()
 	if s.mdls == nil {
 		s.mdls = mdls
 		return
@@ -63,12 +103,18 @@ func (s *HttpServer) serve(ctx *context.Context) {
 }
 
 func (s *HttpServer) flushResp(ctx *context.Context) {
+	// 响应已经流式写回，再写会在后面追加一个空的响应体；
+	// 连接被接管之后则根本不能再写
+	if ctx.Streamed() || ctx.Hijacked() {
+		return
+	}
 	if ctx.RespStatusCode > 0 {
 		ctx.Resp.WriteHeader(ctx.RespStatusCode)
 	}
 	_, err := ctx.Resp.Write(ctx.RespData)
 	if err != nil {
-		log.Fatalln("回写响应失败", err)
+		// 一般是客户端已经断开了，不能因为一个请求让整个进程退出
+		log.Println("web: 回写响应失败", err)
 	}
 }
 
@@ -79,16 +125,35 @@ func (s *HttpServer) flushRespMiddleware(next handler.Handle) handler.Handle {
 	}
 }
 
-func (s *HttpServer) Get(path string, handle handler.Handle) {
-	s.AddRoute(http.MethodGet, path, handle)
+func (s *HttpServer) AddRoute(method, path string, handle handler.Handle, mdls ...middleware.Middleware) {
+	s.checkFrozen()
+	s.Router.AddRoute(method, path, handle, mdls...)
+}
+
+func (s *HttpServer) AddNamedRoute(name, method, path string, handle handler.Handle, mdls ...middleware.Middleware) {
+	s.checkFrozen()
+	s.Router.AddNamedRoute(name, method, path, handle, mdls...)
+}
+
+func (s *HttpServer) checkFrozen() {
+	if s.frozen.Load() {
+		panic("web: 服务器已经开始处理请求，不能再注册路由或者中间件")
+	}
+}
+
+func (s *HttpServer) Get(path string, handle handler.Handle, mdls ...middleware.Middleware) {
+	s.AddRoute(http.MethodGet, path, handle, mdls...)
 }
 
-func (s *HttpServer) Post(path string, handle handler.Handle) {
-	s.AddRoute(http.MethodPost, path, handle)
+func (s *HttpServer) Post(path string, handle handler.Handle, mdls ...middleware.Middleware) {
+	s.AddRoute(http.MethodPost, path, handle, mdls...)
 }
 
 func NewHttpServer(opts ...ServerOption) *HttpServer {
 	s := &HttpServer{Router: route.NewRouter()}
+	s.ctxPool.New = func() any {
+		return &context.Context{}
+	}
 	for _, opt := range opts {
 		opt(s)
 	}
@@ -100,3 +165,36 @@ func ServerWithTemplateEngine(engine template.TemplateEngine) ServerOption {
 		server.tplEngine = engine
 	}
 }
+
+// ServerWithKeyring 设置签名和加密 cookie 用的密钥
+func ServerWithKeyring(kr *keyring.Keyring) ServerOption {
+	return func(server *HttpServer) {
+		server.keyring = kr
+	}
+}
+
+// ServerWithMaxBodySize 限制所有请求的请求体大小。
+// 单个路由可以通过 bodylimit 中间件设置更小的限制
+func ServerWithMaxBodySize(size int64) ServerOption {
+	return func(server *HttpServer) {
+		server.maxBodySize = size
+	}
+}
+
+// ServerWithTrustedProxies 设置可信的反向代理，
+// 只有来自它们的 X-Forwarded-For 和 X-Real-IP 才会被 Context.ClientIP 采信
+func ServerWithTrustedProxies(cidrs ...string) ServerOption {
+	return func(server *HttpServer) {
+		for _, cidr := range cidrs {
+			server.trustedProxies = append(server.trustedProxies, netip.MustParsePrefix(cidr))
+		}
+	}
+}
+
+// ServerWithoutContextPool 让每个请求都创建新的 Context，而不是从池子里复用。
+// 只有在 handler 会在返回之后继续使用 Context，又不方便改成 Context.Copy 的时候才需要
+func ServerWithoutContextPool() ServerOption {
+	return func(server *HttpServer) {
+		server.disableCtxPool = true
+	}
+}
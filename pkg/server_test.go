@@ -0,0 +1,356 @@
+package sepweb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"github.com/igevin/sepweb/pkg/middleware"
+	"github.com/igevin/sepweb/pkg/middleware/bodylimit"
+	"github.com/igevin/sepweb/pkg/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestHttpServer_Stream(t *testing.T) {
+	s := NewHttpServer()
+	s.Get("/export", func(ctx *context.Context) {
+		ctx.RespData = []byte("should not be written")
+		err := ctx.Stream("text/csv", func(w io.Writer) error {
+			for i := 0; i < 3; i++ {
+				if _, err := fmt.Fprintf(w, "line-%d\n", i); err != nil {
+					return err
+				}
+				ctx.Flush()
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/export", nil)
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/csv", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "line-0\nline-1\nline-2\n", recorder.Body.String())
+	assert.True(t, recorder.Flushed)
+}
+
+func TestHttpServer_UpgradeWebSocket(t *testing.T) {
+	s := NewHttpServer()
+	s.Get("/ws", func(ctx *context.Context) {
+		conn, err := ctx.UpgradeWebSocket()
+		if err != nil {
+			return
+		}
+		defer conn.Close(websocket.CloseNormalClosure, "")
+		_ = conn.WriteText("hello")
+	})
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	// 普通的请求握手失败，由 flushResp 写回 400
+	resp, err := http.Get(server.URL + "/ws")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\n" +
+		"Upgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	upgradeResp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, upgradeResp.StatusCode)
+	// 接下来应该直接是 WebSocket 的帧，而不是 flushResp 写出来的东西
+	frame := make([]byte, 7)
+	_, err = io.ReadFull(reader, frame)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x81, 5, 'h', 'e', 'l', 'l', 'o'}, frame)
+}
+
+func BenchmarkHttpServer_ServeHTTP(b *testing.B) {
+	testCases := []struct {
+		name string
+		opts []ServerOption
+	}{
+		{
+			name: "context pool",
+		},
+		{
+			name: "without context pool",
+			opts: []ServerOption{ServerWithoutContextPool()},
+		},
+	}
+	for _, tc := range testCases {
+		b.Run(tc.name, func(b *testing.B) {
+			s := NewHttpServer(tc.opts...)
+			for i := 0; i < 5; i++ {
+				s.Use(func(next handler.Handle) handler.Handle {
+					return func(ctx *context.Context) {
+						next(ctx)
+					}
+				})
+			}
+			key := context.NewKey[string]("user")
+			s.Get("/users/:id", func(ctx *context.Context) {
+				// 模板函数、CSRF 这些常见的用法都会通过 Value 读取值
+				_ = ctx.Value(key)
+				ctx.RespStatusCode = http.StatusOK
+				ctx.RespData = []byte("hello")
+			})
+			req := httptest.NewRequest(http.MethodGet, "/users/123", nil)
+			w := httptest.NewRecorder()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w.Body.Reset()
+				s.ServeHTTP(w, req)
+			}
+		})
+	}
+}
+
+func TestHttpServer_Frozen(t *testing.T) {
+	s := NewHttpServer()
+	s.Get("/", func(ctx *context.Context) {})
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Panics(t, func() {
+		s.Get("/late", func(ctx *context.Context) {})
+	})
+	assert.Panics(t, func() {
+		s.Use(func(next handler.Handle) handler.Handle {
+			return next
+		})
+	})
+}
+
+func TestHttpServer_ContextReuse(t *testing.T) {
+	s := NewHttpServer()
+	var retained *context.Context
+	var retainedResp http.ResponseWriter
+	var retainedSSE *context.SSEWriter
+	s.Get("/events", func(ctx *context.Context) {
+		retainedSSE = ctx.SSE()
+	})
+	s.Get("/first", func(ctx *context.Context) {
+		ctx.UserValues = map[string]any{"user": "Tom"}
+		retained = ctx
+		retainedResp = ctx.Resp
+		ctx.RespData = []byte("first")
+	})
+	s.Get("/second", func(ctx *context.Context) {
+		assert.Nil(t, ctx.UserValues)
+		// 上一个请求留下来的 ResponseWriter 写不到这个请求里
+		_, err := retainedResp.Write([]byte("late"))
+		assert.Equal(t, context.ErrContextReleased, err)
+		assert.Equal(t, context.ErrContextReleased, retainedSSE.Send(context.SSEEvent{Data: "late"}))
+		retainedResp.Header().Set("X-Late", "late")
+		ctx.RespData = []byte("second")
+	})
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/events", nil))
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/first", nil))
+
+	// 请求结束之后、被复用之前继续写响应
+	_, err := retained.Resp.Write([]byte("late"))
+	assert.Equal(t, context.ErrContextReleased, err)
+	_, err = retainedResp.Write([]byte("late"))
+	assert.Equal(t, context.ErrContextReleased, err)
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/second", nil))
+	assert.Equal(t, "second", recorder.Body.String())
+	assert.Empty(t, recorder.Header().Get("X-Late"))
+}
+
+func TestHttpServer_Abort(t *testing.T) {
+	s := NewHttpServer()
+	var logs []string
+	s.Use(func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			next(ctx)
+			logs = append(logs, fmt.Sprintf("aborted=%v, status=%d, err=%v",
+				ctx.IsAborted(), ctx.RespStatusCode, ctx.AbortError()))
+		}
+	})
+	s.Use(func(next handler.Handle) handler.Handle {
+		return func(ctx *context.Context) {
+			if ctx.Req.Header.Get("Authorization") == "" {
+				ctx.AbortWithError(http.StatusUnauthorized, errors.New("no token"))
+			}
+			// 即便中断之后继续调用 next，后面的 handler 也不会执行
+			next(ctx)
+		}
+	})
+	s.Get("/resource", func(ctx *context.Context) {
+		ctx.RespData = []byte("resource")
+	})
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/resource", nil))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, "", recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/resource", nil)
+	req.Header.Set("Authorization", "token")
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, "resource", recorder.Body.String())
+
+	assert.Equal(t, []string{
+		"aborted=true, status=401, err=no token",
+		"aborted=false, status=0, err=<nil>",
+	}, logs)
+}
+
+func TestHttpServer_MaxBodySize(t *testing.T) {
+	type user struct {
+		Name string `json:"name"`
+	}
+	bindHandler := func(ctx *context.Context) {
+		u := &user{}
+		if err := ctx.BindJson(u); err != nil {
+			if err != context.ErrBodyTooLarge {
+				ctx.RespStatusCode = http.StatusBadRequest
+			}
+			return
+		}
+		// 读取过的请求体依旧可以拿到，比如用来验签
+		raw, err := ctx.Body()
+		require.NoError(t, err)
+		direct, err := io.ReadAll(ctx.Req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, raw, direct)
+		ctx.RespData = []byte(u.Name + ":" + string(raw))
+	}
+
+	s := NewHttpServer(ServerWithMaxBodySize(32))
+	s.Post("/user", bindHandler)
+	s.Post("/small", bindHandler, bodylimit.NewMiddlewareBuilder(8).Build())
+	s.Post("/form", func(ctx *context.Context) {
+		name, err := ctx.FormValue("name").ToString()
+		if err != nil {
+			return
+		}
+		raw, _ := ctx.Body()
+		ctx.RespData = []byte(name + ":" + string(raw))
+	})
+
+	testCases := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		wantCode    int
+		wantBody    string
+	}{
+		{
+			name:     "bind then read raw body",
+			path:     "/user",
+			body:     `{"name":"Tom"}`,
+			wantCode: http.StatusOK,
+			wantBody: `Tom:{"name":"Tom"}`,
+		},
+		{
+			name:     "server limit",
+			path:     "/user",
+			body:     `{"name":"` + strings.Repeat("a", 32) + `"}`,
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:     "route limit",
+			path:     "/small",
+			body:     `{"name":"Tom"}`,
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "form then read raw body",
+			path:        "/form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "name=Tom",
+			wantCode:    http.StatusOK,
+			wantBody:    "Tom:name=Tom",
+		},
+		{
+			name:        "form too large",
+			path:        "/form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "name=" + strings.Repeat("a", 32),
+			wantCode:    http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+
+	// 正好读到限制的时候连接出错，不能当成请求体过大
+	errReset := errors.New("connection reset")
+	s = NewHttpServer(ServerWithMaxBodySize(8))
+	s.Post("/raw", func(ctx *context.Context) {
+		_, err := ctx.Body()
+		assert.Equal(t, errReset, err)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/raw",
+		io.MultiReader(strings.NewReader("12345678"), iotest.ErrReader(errReset)))
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.NotEqual(t, http.StatusRequestEntityTooLarge, recorder.Code)
+}
+
+func TestHttpServer_Group(t *testing.T) {
+	var logs []string
+	logMdl := func(name string) middleware.Middleware {
+		return func(next handler.Handle) handler.Handle {
+			return func(ctx *context.Context) {
+				logs = append(logs, name)
+				next(ctx)
+			}
+		}
+	}
+	s := NewHttpServer()
+	api := s.Group("/api", logMdl("api"))
+	v1 := api.Group("/v1", logMdl("v1"))
+	api.Get("/", func(ctx *context.Context) {
+		ctx.RespData = []byte("api")
+	})
+	v1.Get("/users/:id", func(ctx *context.Context) {
+		ctx.RespData = []byte("user " + ctx.PathParams["id"])
+	}, logMdl("route"))
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/users/1", nil))
+	assert.Equal(t, "user 1", recorder.Body.String())
+	assert.Equal(t, []string{"api", "v1", "route"}, logs)
+
+	logs = nil
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api", nil))
+	assert.Equal(t, "api", recorder.Body.String())
+	assert.Equal(t, []string{"api"}, logs)
+
+	assert.Panics(t, func() {
+		s.Group("/api/")
+	})
+}
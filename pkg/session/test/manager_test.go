@@ -61,7 +61,7 @@ func TestManager(t *testing.T) {
 				sess, err := m.GetSession(ctx)
 				// 不管发生了什么错误，对于用户我们都是返回未授权
 				if err != nil {
-					ctx.RespStatusCode = http.StatusUnauthorized
+					ctx.AbortWithError(http.StatusUnauthorized, err)
 					return
 				}
 				ctx.UserValues["sess"] = sess
@@ -0,0 +1,213 @@
+package sse
+
+import (
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/igevin/sepweb/pkg/handler"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type HubOption func(hub *Hub)
+
+// Hub 按照 topic 把事件广播给订阅的客户端，
+// 并为每个 topic 保留最近的若干条事件，用于 Last-Event-ID 重放。
+// topic 在用到的时候创建，没有订阅者并且重放的事件为空或者过期之后会被删除，
+// 所以可以按照用户或者房间来划分 topic
+type Hub struct {
+	mu     sync.Mutex
+	topics map[string]*topic
+	// lastSweep 是上一次清理过期 topic 的时间
+	lastSweep time.Time
+	now       func() time.Time
+
+	replaySize int
+	replayTTL  time.Duration
+	bufferSize int
+	heartbeat  time.Duration
+}
+
+type topic struct {
+	seq    uint64
+	replay []context.SSEEvent
+	// updated 是最后一次发布事件的时间
+	updated time.Time
+	subs    map[*Subscription]struct{}
+}
+
+// Subscription 是一个客户端对某个 topic 的订阅
+type Subscription struct {
+	hub    *Hub
+	topic  string
+	events chan context.SSEEvent
+	closed bool
+}
+
+func NewHub(opts ...HubOption) *Hub {
+	res := &Hub{
+		topics:     make(map[string]*topic),
+		now:        time.Now,
+		replaySize: 64,
+		replayTTL:  5 * time.Minute,
+		bufferSize: 16,
+		heartbeat:  15 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// WithReplaySize 设置每个 topic 最多保留多少条事件用于重放
+func WithReplaySize(size int) HubOption {
+	return func(hub *Hub) {
+		hub.replaySize = size
+	}
+}
+
+// WithReplayTTL 设置没有订阅者的 topic 的重放事件保留多久，过期之后整个 topic 会被删除。
+// 0 表示最后一个订阅者离开之后立刻删除
+func WithReplayTTL(ttl time.Duration) HubOption {
+	return func(hub *Hub) {
+		hub.replayTTL = ttl
+	}
+}
+
+// WithSubscriberBuffer 设置每个订阅者的缓冲区大小，
+// 缓冲区满了说明客户端跟不上，这个订阅会被直接关闭
+func WithSubscriberBuffer(size int) HubOption {
+	return func(hub *Hub) {
+		hub.bufferSize = size
+	}
+}
+
+// WithHeartbeat 设置心跳间隔，0 表示不发送心跳
+func WithHeartbeat(interval time.Duration) HubOption {
+	return func(hub *Hub) {
+		hub.heartbeat = interval
+	}
+}
+
+// Publish 把事件广播给 topic 下所有的订阅者。
+// 事件没有 ID 的时候，会按照 topic 生成一个递增的 ID
+func (h *Hub) Publish(topicName string, e context.SSEEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	h.sweepLocked(now)
+	t := h.topicOrCreate(topicName)
+	t.seq++
+	t.updated = now
+	if e.ID == "" {
+		e.ID = strconv.FormatUint(t.seq, 10)
+	}
+	if h.replaySize > 0 {
+		if len(t.replay) >= h.replaySize {
+			t.replay = t.replay[1:]
+		}
+		t.replay = append(t.replay, e)
+	}
+	for sub := range t.subs {
+		select {
+		case sub.events <- e:
+		default:
+			h.closeLocked(sub)
+		}
+	}
+	h.removeIfIdleLocked(topicName, t, now)
+}
+
+// Subscribe 订阅 topic。lastEventID 不为空的时候，
+// 会先把缓冲区里在它之后的事件重放给订阅者；
+// 如果 lastEventID 已经不在缓冲区里了，就重放整个缓冲区
+func (h *Hub) Subscribe(topicName string, lastEventID string) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sweepLocked(h.now())
+	t := h.topicOrCreate(topicName)
+	var missed []context.SSEEvent
+	if lastEventID != "" {
+		missed = t.replay
+		for i, e := range t.replay {
+			if e.ID == lastEventID {
+				missed = t.replay[i+1:]
+				break
+			}
+		}
+	}
+	sub := &Subscription{
+		hub:    h,
+		topic:  topicName,
+		events: make(chan context.SSEEvent, h.bufferSize+len(missed)),
+	}
+	for _, e := range missed {
+		sub.events <- e
+	}
+	t.subs[sub] = struct{}{}
+	return sub
+}
+
+// Serve 把当前请求订阅到 topic 上，并持续推送事件，直到客户端断开连接
+func (h *Hub) Serve(ctx *context.Context, topicName string) error {
+	w := ctx.SSE()
+	sub := h.Subscribe(topicName, w.LastEventID())
+	defer sub.Close()
+	return w.Serve(sub.Events(), h.heartbeat)
+}
+
+// Handle 返回一个订阅固定 topic 的 handler
+func (h *Hub) Handle(topicName string) handler.Handle {
+	return func(ctx *context.Context) {
+		_ = h.Serve(ctx, topicName)
+	}
+}
+
+func (h *Hub) topicOrCreate(name string) *topic {
+	t, ok := h.topics[name]
+	if !ok {
+		t = &topic{subs: make(map[*Subscription]struct{})}
+		h.topics[name] = t
+	}
+	return t
+}
+
+func (h *Hub) closeLocked(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.events)
+	if t, ok := h.topics[sub.topic]; ok {
+		delete(t.subs, sub)
+		h.removeIfIdleLocked(sub.topic, t, h.now())
+	}
+}
+
+// removeIfIdleLocked 删除没有订阅者，也没有需要重放的事件的 topic
+func (h *Hub) removeIfIdleLocked(name string, t *topic, now time.Time) {
+	if len(t.subs) == 0 && (len(t.replay) == 0 || now.Sub(t.updated) >= h.replayTTL) {
+		delete(h.topics, name)
+	}
+}
+
+// sweepLocked 清理重放事件已经过期的 topic，每隔 replayTTL 才会遍历一次
+func (h *Hub) sweepLocked(now time.Time) {
+	if now.Sub(h.lastSweep) < h.replayTTL {
+		return
+	}
+	h.lastSweep = now
+	for name, t := range h.topics {
+		h.removeIfIdleLocked(name, t, now)
+	}
+}
+
+// Events 返回订阅到的事件，订阅关闭之后 channel 也会被关闭
+func (s *Subscription) Events() <-chan context.SSEEvent {
+	return s.events
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.closeLocked(s)
+}
@@ -0,0 +1,129 @@
+package sse
+
+import (
+	"bufio"
+	sepweb "github.com/igevin/sepweb/pkg"
+	"github.com/igevin/sepweb/pkg/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHub_Subscribe(t *testing.T) {
+	testCases := []struct {
+		name        string
+		lastEventID string
+		wantIDs     []string
+	}{
+		{
+			name: "no last event id",
+		},
+		{
+			name:        "replay after last event id",
+			lastEventID: "3",
+			wantIDs:     []string{"4", "5"},
+		},
+		{
+			name:        "last event id evicted",
+			lastEventID: "1",
+			wantIDs:     []string{"3", "4", "5"},
+		},
+		{
+			name:        "up to date",
+			lastEventID: "5",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHub(WithReplaySize(3))
+			for i := 0; i < 5; i++ {
+				h.Publish("news", context.SSEEvent{Data: "hello"})
+			}
+			sub := h.Subscribe("news", tc.lastEventID)
+			sub.Close()
+			var ids []string
+			for e := range sub.Events() {
+				ids = append(ids, e.ID)
+			}
+			assert.Equal(t, tc.wantIDs, ids)
+		})
+	}
+}
+
+func TestHub_SlowSubscriber(t *testing.T) {
+	h := NewHub(WithSubscriberBuffer(1))
+	sub := h.Subscribe("news", "")
+	h.Publish("news", context.SSEEvent{Data: "1"})
+	h.Publish("news", context.SSEEvent{Data: "2"})
+	e, ok := <-sub.Events()
+	assert.True(t, ok)
+	assert.Equal(t, "1", e.Data)
+	_, ok = <-sub.Events()
+	assert.False(t, ok)
+}
+
+func TestHub_RemoveIdleTopics(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	h := NewHub(WithReplaySize(0), WithReplayTTL(time.Minute))
+	h.now = func() time.Time {
+		return now
+	}
+
+	// 没有重放事件的 topic，最后一个订阅者离开之后就删除
+	sub := h.Subscribe("user:1", "")
+	h.Publish("user:1", context.SSEEvent{Data: "hello"})
+	h.Publish("user:2", context.SSEEvent{Data: "nobody"})
+	assert.Len(t, h.topics, 1)
+	sub.Close()
+	assert.Empty(t, h.topics)
+
+	// 有重放事件的 topic 保留到过期
+	h.replaySize = 3
+	h.Publish("room:1", context.SSEEvent{Data: "hello"})
+	h.Subscribe("room:1", "").Close()
+	assert.Len(t, h.topics, 1)
+	now = now.Add(time.Minute)
+	h.Publish("room:2", context.SSEEvent{Data: "hello"})
+	assert.Len(t, h.topics, 1)
+	assert.Contains(t, h.topics, "room:2")
+}
+
+func TestHub_Serve(t *testing.T) {
+	h := NewHub(WithHeartbeat(0))
+	s := sepweb.NewHttpServer()
+	s.Get("/events", h.Handle("news"))
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	h.Publish("news", context.SSEEvent{Event: "greeting", Data: "first"})
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		h.Publish("news", context.SSEEvent{Data: "second\nline"})
+	}()
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 8 {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	assert.Equal(t, []string{
+		"id: 1", "event: greeting", "data: first", "",
+		"id: 2", "data: second", "data: line", "",
+	}, lines)
+}
@@ -3,40 +3,135 @@ package template
 import (
 	"bytes"
 	"context"
+	"errors"
 	"html/template"
 	"io/fs"
+	"sync"
 )
 
 type TemplateEngine interface {
 	Render(ctx context.Context, tplName string, data any) ([]byte, error)
 }
 
+// ContextFunc 是依赖请求的模板函数，比如输出 CSRF token。
+// 它在每次渲染的时候用当前请求的 ctx 调用，模板里以无参函数的形式使用，比如 {{ csrfField }}
+type ContextFunc func(ctx context.Context) any
+
 type GoTemplateEngine struct {
 	T *template.Template
+
+	funcs    template.FuncMap
+	ctxFuncs map[string]ContextFunc
+	// bound 缓存绑定了 ContextFunc 的克隆，见 boundTemplate
+	bound sync.Pool
+}
+
+// boundTemplate 是 T 的克隆，它的 ContextFunc 读取 ctx 字段，渲染之前设置成当前请求。
+// html/template 在第一次执行的时候才做上下文相关的转义，克隆复用之后就不用每次都转义整个模板集合
+type boundTemplate struct {
+	src *template.Template
+	t   *template.Template
+	ctx context.Context
+}
+
+// Funcs 注册模板函数，需要在 LoadFromXXX 之前调用
+func (g *GoTemplateEngine) Funcs(funcs template.FuncMap) *GoTemplateEngine {
+	if g.funcs == nil {
+		g.funcs = template.FuncMap{}
+	}
+	for name, fn := range funcs {
+		g.funcs[name] = fn
+	}
+	return g
+}
+
+// ContextFuncs 注册依赖请求的模板函数，需要在 LoadFromXXX 之前调用
+func (g *GoTemplateEngine) ContextFuncs(funcs map[string]ContextFunc) *GoTemplateEngine {
+	if g.ctxFuncs == nil {
+		g.ctxFuncs = map[string]ContextFunc{}
+	}
+	for name, fn := range funcs {
+		g.ctxFuncs[name] = fn
+	}
+	return g
 }
 
 func (g *GoTemplateEngine) Render(ctx context.Context, tplName string, data any) ([]byte, error) {
 	res := &bytes.Buffer{}
-	err := g.T.ExecuteTemplate(res, tplName, data)
+	if len(g.ctxFuncs) == 0 {
+		err := g.T.ExecuteTemplate(res, tplName, data)
+		return res.Bytes(), err
+	}
+	b, err := g.acquire()
+	if err != nil {
+		return nil, err
+	}
+	b.ctx = ctx
+	err = b.t.ExecuteTemplate(res, tplName, data)
+	b.ctx = nil
+	g.bound.Put(b)
 	return res.Bytes(), err
 }
 
+// acquire 从池子里拿一个克隆，一个克隆同时只会被一个请求使用。
+// 重新加载过模板的话，之前的克隆会被丢弃
+func (g *GoTemplateEngine) acquire() (*boundTemplate, error) {
+	for {
+		b, ok := g.bound.Get().(*boundTemplate)
+		if !ok {
+			break
+		}
+		if b.src == g.T {
+			return b, nil
+		}
+	}
+	t, err := g.T.Clone()
+	if err != nil {
+		return nil, err
+	}
+	b := &boundTemplate{src: g.T, t: t}
+	funcs := make(template.FuncMap, len(g.ctxFuncs))
+	for name, fn := range g.ctxFuncs {
+		fn := fn
+		funcs[name] = func() any {
+			return fn(b.ctx)
+		}
+	}
+	t.Funcs(funcs)
+	return b, nil
+}
+
+// newTemplate 创建带有全部模板函数的根模板。
+// 解析的时候 ContextFunc 还没有请求可以绑定，先用占位函数，渲染的时候再替换
+func (g *GoTemplateEngine) newTemplate() *template.Template {
+	funcs := make(template.FuncMap, len(g.funcs)+len(g.ctxFuncs))
+	for name, fn := range g.funcs {
+		funcs[name] = fn
+	}
+	for name := range g.ctxFuncs {
+		funcs[name] = func() (any, error) {
+			return nil, errors.New("template: 只能在渲染的时候调用")
+		}
+	}
+	return template.New("").Funcs(funcs)
+}
+
 // 以下这三个方法，可以加可以不加，看你是什么风格的设计者
 
 func (g *GoTemplateEngine) LoadFromGlob(pattern string) error {
 	var err error
-	g.T, err = template.ParseGlob(pattern)
+	g.T, err = g.newTemplate().ParseGlob(pattern)
 	return err
 }
 
 func (g *GoTemplateEngine) LoadFromFiles(filenames ...string) error {
 	var err error
-	g.T, err = template.ParseFiles(filenames...)
+	g.T, err = g.newTemplate().ParseFiles(filenames...)
 	return err
 }
 
 func (g *GoTemplateEngine) LoadFromFS(fs fs.FS, patterns ...string) error {
 	var err error
-	g.T, err = template.ParseFS(fs, patterns...)
+	g.T, err = g.newTemplate().ParseFS(fs, patterns...)
 	return err
 }
@@ -0,0 +1,160 @@
+package trace
+
+import (
+	stdctx "context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// 规范允许丢弃总长度超过 512 的 tracestate
+const maxTracestateLength = 512
+
+var ErrInvalidTraceparent = errors.New("trace: 非法的 traceparent")
+
+type TraceID [16]byte
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+type SpanID [8]byte
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+const flagSampled byte = 0x01
+
+// SpanContext 是需要在服务之间传递的那部分 span 信息
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+	// Remote 表示这是从上游传过来的
+	Remote bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&flagSampled != 0
+}
+
+// Traceparent 按照 W3C Trace Context 的格式输出，比如
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// ParseTraceparent 解析 traceparent 头部。
+// 未来版本的格式可能会在后面追加字段，这里只认前面四个
+func ParseTraceparent(val string) (SpanContext, error) {
+	val = strings.TrimSpace(val)
+	if len(val) < 55 || (len(val) > 55 && val[55] != '-') {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	if val[2] != '-' || val[35] != '-' || val[52] != '-' {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	version, ok := decodeLowerHex(val[0:2])
+	// ff 是非法版本；00 版本的长度必须刚好是 55
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(val) != 55) {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	var sc SpanContext
+	traceID, ok := decodeLowerHex(val[3:35])
+	if !ok {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	spanID, ok := decodeLowerHex(val[36:52])
+	if !ok {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	copy(sc.SpanID[:], spanID)
+	flags, ok := decodeLowerHex(val[53:55])
+	if !ok {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	sc.Remote = true
+	return sc, nil
+}
+
+// decodeLowerHex 规范只允许小写的十六进制
+func decodeLowerHex(s string) ([]byte, bool) {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return nil, false
+		}
+	}
+	bs, err := hex.DecodeString(s)
+	return bs, err == nil
+}
+
+// Extract 从请求头里解析上游的 SpanContext
+func Extract(header http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	// 多个 tracestate 头部等价于用逗号连起来
+	state := strings.Join(header.Values(TracestateHeader), ",")
+	if len(state) <= maxTracestateLength {
+		sc.TraceState = state
+	}
+	return sc, true
+}
+
+// Inject 把 ctx 里的 span 写到请求头里，传给下游服务
+func Inject(ctx stdctx.Context, header http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	sc := span.SpanContext()
+	header.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		header.Set(TracestateHeader, sc.TraceState)
+	}
+}
+
+// Transport 把请求 context 里的 span 带到发往下游服务的请求上
+type Transport struct {
+	// Base 为 nil 的时候使用 http.DefaultTransport
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if SpanFromContext(req.Context()) != nil {
+		// RoundTripper 不应该修改传进来的请求
+		req = req.Clone(req.Context())
+		Inject(req.Context(), req.Header)
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,120 @@
+package trace
+
+import (
+	stdctx "context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	testCases := []struct {
+		name    string
+		val     string
+		wantErr error
+		sampled bool
+	}{
+		{
+			name:    "sampled",
+			val:     "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			sampled: true,
+		},
+		{
+			name: "not sampled",
+			val:  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		},
+		{
+			name:    "future version with extra fields",
+			val:     "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			sampled: true,
+		},
+		{
+			name:    "version 00 with extra fields",
+			val:     "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			wantErr: ErrInvalidTraceparent,
+		},
+		{
+			name:    "invalid version",
+			val:     "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantErr: ErrInvalidTraceparent,
+		},
+		{
+			name:    "upper case",
+			val:     "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			wantErr: ErrInvalidTraceparent,
+		},
+		{
+			name:    "zero trace id",
+			val:     "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			wantErr: ErrInvalidTraceparent,
+		},
+		{
+			name:    "zero span id",
+			val:     "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			wantErr: ErrInvalidTraceparent,
+		},
+		{
+			name:    "too short",
+			val:     "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+			wantErr: ErrInvalidTraceparent,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tc.val)
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+			assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+			assert.Equal(t, tc.sampled, sc.IsSampled())
+			assert.True(t, sc.Remote)
+		})
+	}
+}
+
+func TestTracer_Start(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	header.Add(TracestateHeader, "a=1")
+	header.Add(TracestateHeader, "b=2")
+	remote, ok := Extract(header)
+	require.True(t, ok)
+	assert.Equal(t, "a=1,b=2", remote.TraceState)
+
+	ctx := ContextWithRemoteSpanContext(stdctx.Background(), remote)
+	ctx, parent := tracer.Start(ctx, "parent")
+	_, child := tracer.Start(ctx, "child")
+	child.End()
+	parent.End()
+	parent.End()
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, parent.SpanContext().SpanID.String(), spans[0].ParentSpanID)
+	assert.Equal(t, "parent", spans[1].Name)
+	assert.Equal(t, "00f067aa0ba902b7", spans[1].ParentSpanID)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].TraceID)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[1].TraceID)
+
+	// 传给下游的是当前 span 的 ID
+	out := http.Header{}
+	Inject(ctx, out)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+parent.SpanContext().SpanID.String()+"-01",
+		out.Get(TraceparentHeader))
+	assert.Equal(t, "a=1,b=2", out.Get(TracestateHeader))
+
+	// 上游没有采样的 trace 不会导出
+	exporter.Reset()
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	remote, _ = Extract(header)
+	_, span := tracer.Start(ContextWithRemoteSpanContext(stdctx.Background(), remote), "dropped")
+	span.End()
+	assert.Empty(t, exporter.Spans())
+}
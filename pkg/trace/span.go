@@ -0,0 +1,177 @@
+package trace
+
+import (
+	stdctx "context"
+	"sync"
+	"time"
+)
+
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+func (s StatusCode) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusError:
+		return "error"
+	}
+	return "unset"
+}
+
+func (s StatusCode) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type Event struct {
+	Name       string         `json:"name"`
+	Time       time.Time      `json:"time"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// SpanData 是结束之后交给 Exporter 的 span 快照
+type SpanData struct {
+	Name              string         `json:"name"`
+	TraceID           string         `json:"trace_id"`
+	SpanID            string         `json:"span_id"`
+	ParentSpanID      string         `json:"parent_span_id,omitempty"`
+	TraceState        string         `json:"trace_state,omitempty"`
+	Start             time.Time      `json:"start"`
+	End               time.Time      `json:"end"`
+	Status            StatusCode     `json:"status"`
+	StatusDescription string         `json:"status_description,omitempty"`
+	Attributes        map[string]any `json:"attributes,omitempty"`
+	Events            []Event        `json:"events,omitempty"`
+}
+
+// Span 表示一次操作。它可以被多个 goroutine 同时使用
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+
+	mu         sync.Mutex
+	name       string
+	start      time.Time
+	end        time.Time
+	status     StatusCode
+	statusDesc string
+	attrs      map[string]any
+	events     []Event
+	ended      bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	return s.sc
+}
+
+// SetName 修改 span 的名字，比如在路由匹配之后改成路由的路径
+func (s *Span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+func (s *Span) SetAttribute(key string, val any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]any, 4)
+	}
+	s.attrs[key] = val
+}
+
+func (s *Span) AddEvent(name string, attrs map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, Event{Name: name, Time: time.Now(), Attributes: attrs})
+}
+
+// SetStatus 设置状态。已经是 StatusOK 的 span 不会再被改成其它状态
+func (s *Span) SetStatus(code StatusCode, desc string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == StatusOK {
+		return
+	}
+	s.status = code
+	s.statusDesc = ""
+	if code == StatusError {
+		s.statusDesc = desc
+	}
+}
+
+// RecordError 记录一个错误事件，并把状态设置为 StatusError
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.AddEvent("exception", map[string]any{"exception.message": err.Error()})
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End 结束 span 并交给 Exporter，重复调用只有第一次生效
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	data := s.snapshot()
+	s.mu.Unlock()
+	if s.sc.IsSampled() {
+		s.tracer.export(data)
+	}
+}
+
+func (s *Span) snapshot() SpanData {
+	data := SpanData{
+		Name:              s.name,
+		TraceID:           s.sc.TraceID.String(),
+		SpanID:            s.sc.SpanID.String(),
+		TraceState:        s.sc.TraceState,
+		Start:             s.start,
+		End:               s.end,
+		Status:            s.status,
+		StatusDescription: s.statusDesc,
+		Events:            s.events,
+	}
+	if s.parent.IsValid() {
+		data.ParentSpanID = s.parent.String()
+	}
+	if len(s.attrs) > 0 {
+		data.Attributes = make(map[string]any, len(s.attrs))
+		for k, v := range s.attrs {
+			data.Attributes[k] = v
+		}
+	}
+	return data
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// ContextWithSpan 把 span 放到 ctx 里，之后在这个 ctx 上创建的 span 都是它的子 span
+func ContextWithSpan(ctx stdctx.Context, span *Span) stdctx.Context {
+	return stdctx.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext 取出当前的 span，ctx 可以是 *context.Context，也可以是请求的 context
+func SpanFromContext(ctx stdctx.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext 记录上游传过来的 SpanContext，
+// 之后在这个 ctx 上创建的 span 会加入上游的 trace
+func ContextWithRemoteSpanContext(ctx stdctx.Context, sc SpanContext) stdctx.Context {
+	return stdctx.WithValue(ctx, remoteKey{}, sc)
+}
@@ -0,0 +1,93 @@
+package trace
+
+import (
+	stdctx "context"
+	"crypto/rand"
+	"log"
+	"time"
+)
+
+// Exporter 负责把结束的 span 发送出去。Export 会在调用 Span.End 的 goroutine 里执行
+type Exporter interface {
+	Export(span SpanData) error
+}
+
+type TracerOption func(t *Tracer)
+
+type Tracer struct {
+	exporter Exporter
+	// sampler 决定新开的 trace 是否采样，加入上游 trace 的时候沿用上游的决定
+	sampler func() bool
+}
+
+func NewTracer(exporter Exporter, opts ...TracerOption) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		sampler: func() bool {
+			return true
+		},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// WithSampler 设置新开的 trace 是否采样
+func WithSampler(fn func() bool) TracerOption {
+	return func(t *Tracer) {
+		t.sampler = fn
+	}
+}
+
+// Start 创建一个新的 span。ctx 里有 span 的话就是它的子 span，
+// 有上游传过来的 SpanContext 就加入上游的 trace，否则开启一个新的 trace
+func (t *Tracer) Start(ctx stdctx.Context, name string) (stdctx.Context, *Span) {
+	span := &Span{tracer: t, name: name, start: time.Now()}
+	var parent SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.SpanContext()
+	} else if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		parent = sc
+	}
+	if parent.IsValid() {
+		span.sc = SpanContext{
+			TraceID:    parent.TraceID,
+			Flags:      parent.Flags,
+			TraceState: parent.TraceState,
+		}
+		span.parent = parent.SpanID
+	} else {
+		span.sc.TraceID = newTraceID()
+		if t.sampler() {
+			span.sc.Flags = flagSampled
+		}
+	}
+	span.sc.SpanID = newSpanID()
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) export(data SpanData) {
+	if t.exporter == nil {
+		return
+	}
+	if err := t.exporter.Export(data); err != nil {
+		log.Println("trace: 导出 span 失败", err)
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,452 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	continuationFrame             = 0
+	TextMessage       MessageType = 1
+	BinaryMessage     MessageType = 2
+	closeFrame                    = 8
+	pingFrame                     = 9
+	pongFrame                     = 10
+)
+
+// RFC 6455 7.4.1 定义的关闭码
+const (
+	CloseNormalClosure      = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatusReceived   = 1005
+	CloseInvalidPayloadData = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseInternalServerErr  = 1011
+)
+
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	maskBit = 0x80
+
+	maxControlPayload = 125
+)
+
+var (
+	ErrUnexpectedMessageType = errors.New("websocket: 消息类型不符合预期")
+	ErrMessageTooBig         = errors.New("websocket: 消息超过了最大长度")
+	errConnClosed            = errors.New("websocket: 连接已经关闭")
+)
+
+// 压缩之后的消息尾部固定是这四个字节，发送前要去掉，接收后要补回来
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+var flateWriterPool = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// CloseError 表示连接被对端关闭了
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: 连接关闭, code=%d, reason=%s", e.Code, e.Reason)
+}
+
+// Conn 是一个服务端的 WebSocket 连接。
+// 读方法只能在一个 goroutine 里调用，写方法可以并发调用
+type Conn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	subprotocol string
+	compress    bool
+	maxSize     int64
+
+	writeMu sync.Mutex
+	closed  bool
+
+	pongHandler func(data string)
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, subprotocol string, compress bool, maxSize int64) *Conn {
+	return &Conn{
+		conn:        conn,
+		reader:      reader,
+		subprotocol: subprotocol,
+		compress:    compress,
+		maxSize:     maxSize,
+		pongHandler: func(data string) {},
+	}
+}
+
+// Subprotocol 是握手时协商出来的子协议
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetPongHandler 设置收到 pong 时的回调，一般用来刷新读超时
+func (c *Conn) SetPongHandler(fn func(data string)) {
+	c.pongHandler = fn
+}
+
+// ReadMessage 读取一条完整的消息，分片的消息会被拼接起来。
+// ping 会被自动回复 pong，收到 close 时会回复 close 并返回 *CloseError
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		typ        MessageType
+		compressed bool
+		buf        bytes.Buffer
+		started    bool
+	)
+	for {
+		h, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch h.opcode {
+		case pingFrame:
+			if err = c.writeFrame(pongFrame, payload, false); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case pongFrame:
+			c.pongHandler(string(payload))
+			continue
+		case closeFrame:
+			return 0, nil, c.handleClose(payload)
+		case continuationFrame:
+			if !started {
+				return 0, nil, c.fail(CloseProtocolError, "没有起始帧的分片")
+			}
+			// 压缩标记只能设置在第一帧上
+			if h.rsv1 {
+				return 0, nil, c.fail(CloseProtocolError, "分片的后续帧不能设置 RSV1")
+			}
+		case int(TextMessage), int(BinaryMessage):
+			if started {
+				return 0, nil, c.fail(CloseProtocolError, "上一条分片消息还没有结束")
+			}
+			started = true
+			typ = MessageType(h.opcode)
+			compressed = h.rsv1
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "未知的 opcode")
+		}
+
+		if int64(buf.Len()+len(payload)) > c.maxSize {
+			return 0, nil, c.tooBig()
+		}
+		buf.Write(payload)
+		if h.fin {
+			break
+		}
+	}
+
+	data := buf.Bytes()
+	if compressed {
+		var err error
+		if data, err = c.inflate(data); err != nil {
+			return 0, nil, err
+		}
+	}
+	if typ == TextMessage && !utf8.Valid(data) {
+		return 0, nil, c.fail(CloseInvalidPayloadData, "文本消息不是合法的 UTF-8")
+	}
+	return typ, data, nil
+}
+
+func (c *Conn) ReadText() (string, error) {
+	typ, data, err := c.ReadMessage()
+	if err != nil {
+		return "", err
+	}
+	if typ != TextMessage {
+		return "", ErrUnexpectedMessageType
+	}
+	return string(data), nil
+}
+
+func (c *Conn) ReadBinary() ([]byte, error) {
+	typ, data, err := c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if typ != BinaryMessage {
+		return nil, ErrUnexpectedMessageType
+	}
+	return data, nil
+}
+
+// ReadJSON 读取一条文本或者二进制消息，并把它当成 JSON 解析到 val 里
+func (c *Conn) ReadJSON(val any) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, val)
+}
+
+// WriteMessage 把 data 作为一条完整的消息发送出去
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	return c.writeMessage(typ, data, time.Time{})
+}
+
+// writeMessage 在 deadline 之前发送消息，deadline 为零值表示使用 SetWriteDeadline 设置的超时
+func (c *Conn) writeMessage(typ MessageType, data []byte, deadline time.Time) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return ErrUnexpectedMessageType
+	}
+	if !c.compress {
+		return c.writeFrameDeadline(int(typ), data, false, deadline)
+	}
+	compressed, err := deflate(data)
+	if err != nil {
+		return err
+	}
+	return c.writeFrameDeadline(int(typ), compressed, true, deadline)
+}
+
+func (c *Conn) WriteText(text string) error {
+	return c.WriteMessage(TextMessage, []byte(text))
+}
+
+func (c *Conn) WriteBinary(data []byte) error {
+	return c.WriteMessage(BinaryMessage, data)
+}
+
+// WriteJSON 把 val 序列化成 JSON，作为文本消息发送
+func (c *Conn) WriteJSON(val any) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(TextMessage, data)
+}
+
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return ErrMessageTooBig
+	}
+	return c.writeFrame(pingFrame, data, false)
+}
+
+// Close 发送关闭帧并关闭底层连接
+func (c *Conn) Close(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	err := c.writeFrame(closeFrame, payload, false)
+	if er := c.closeNetConn(); err == nil && !errors.Is(er, errConnClosed) {
+		err = er
+	}
+	return err
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	ce := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "非法的关闭帧")
+	case len(payload) >= 2:
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Reason = string(payload[2:])
+	}
+	// 对端主动关闭，按照协议原样回一个关闭帧
+	_ = c.writeFrame(closeFrame, payload, false)
+	_ = c.closeNetConn()
+	return ce
+}
+
+// fail 在对端违反协议的时候关闭连接
+func (c *Conn) fail(code int, reason string) error {
+	_ = c.Close(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+func (c *Conn) tooBig() error {
+	_ = c.Close(CloseMessageTooBig, "")
+	return ErrMessageTooBig
+}
+
+func (c *Conn) closeNetConn() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return errConnClosed
+	}
+	c.closed = true
+	return c.conn.Close()
+}
+
+type frameHeader struct {
+	fin    bool
+	rsv1   bool
+	opcode int
+}
+
+func (c *Conn) readFrame() (frameHeader, []byte, error) {
+	var h frameHeader
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return h, nil, err
+	}
+	h.fin = head[0]&finBit != 0
+	h.rsv1 = head[0]&rsv1Bit != 0
+	h.opcode = int(head[0] & 0x0f)
+	if head[0]&0x30 != 0 || (h.rsv1 && !c.compress) {
+		return h, nil, c.fail(CloseProtocolError, "非法的 RSV 位")
+	}
+	// 客户端发来的帧必须带掩码
+	if head[1]&maskBit == 0 {
+		return h, nil, c.fail(CloseProtocolError, "客户端的帧没有掩码")
+	}
+
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return h, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return h, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+		if length < 0 {
+			return h, nil, c.fail(CloseProtocolError, "非法的帧长度")
+		}
+	}
+
+	if h.opcode >= closeFrame {
+		if !h.fin || length > maxControlPayload {
+			return h, nil, c.fail(CloseProtocolError, "非法的控制帧")
+		}
+	}
+	if length > c.maxSize {
+		return h, nil, c.tooBig()
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return h, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return h, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return h, payload, nil
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte, compressed bool) error {
+	return c.writeFrameDeadline(opcode, payload, compressed, time.Time{})
+}
+
+func (c *Conn) writeFrameDeadline(opcode int, payload []byte, compressed bool, deadline time.Time) error {
+	header := make([]byte, 0, 10)
+	b0 := byte(finBit | opcode)
+	if compressed {
+		b0 |= rsv1Bit
+	}
+	header = append(header, b0)
+	// 服务端发出去的帧不能带掩码
+	switch n := len(payload); {
+	case n <= 125:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		header = append(header, 127)
+		header = append(header, ext[:]...)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return errConnClosed
+	}
+	if !deadline.IsZero() {
+		if err := c.conn.SetWriteDeadline(deadline); err != nil {
+			return err
+		}
+		defer func() {
+			_ = c.conn.SetWriteDeadline(time.Time{})
+		}()
+	}
+	bufs := net.Buffers{header, payload}
+	_, err := bufs.WriteTo(c.conn)
+	return err
+}
+
+func (c *Conn) inflate(data []byte) ([]byte, error) {
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(data),
+		bytes.NewReader(deflateTail),
+		// 补一个空的结束块，让 flate 的读取正常结束
+		strings.NewReader("\x01\x00\x00\xff\xff")))
+	defer r.Close()
+	res, err := io.ReadAll(io.LimitReader(r, c.maxSize+1))
+	if err != nil {
+		return nil, c.fail(CloseInvalidPayloadData, "解压失败")
+	}
+	if int64(len(res)) > c.maxSize {
+		return nil, c.tooBig()
+	}
+	return res, nil
+}
+
+func deflate(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
@@ -0,0 +1,287 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testClient 是一个最简单的客户端，只用来测试服务端的实现
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	header http.Header
+}
+
+func dial(t *testing.T, url string, extensions string) *testClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	require.NoError(t, err)
+	req := "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	if extensions != "" {
+		req += "Sec-WebSocket-Extensions: " + extensions + "\r\n"
+	}
+	_, err = conn.Write([]byte(req + "\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	// RFC 6455 里给出的示例
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	return &testClient{conn: conn, reader: reader, header: resp.Header}
+}
+
+func (c *testClient) writeFrame(t *testing.T, b0 byte, payload []byte) {
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	default:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	require.NoError(t, err)
+}
+
+func (c *testClient) readFrame(t *testing.T) (byte, []byte) {
+	var head [2]byte
+	_, err := io.ReadFull(c.reader, head[:])
+	require.NoError(t, err)
+	length := int(head[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	require.NoError(t, err)
+	return head[0], payload
+}
+
+func newEchoServer(t *testing.T, opts ...Option) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, opts...)
+		if !assert.NoError(t, err) {
+			return
+		}
+		for {
+			typ, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err = conn.WriteMessage(typ, data); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestConn_Echo(t *testing.T) {
+	server := newEchoServer(t)
+	defer server.Close()
+	client := dial(t, server.URL, "")
+	defer client.conn.Close()
+
+	testCases := []struct {
+		name    string
+		frames  [][]byte
+		b0s     []byte
+		wantB0  byte
+		wantMsg string
+	}{
+		{
+			name:    "text",
+			b0s:     []byte{finBit | byte(TextMessage)},
+			frames:  [][]byte{[]byte("hello")},
+			wantB0:  finBit | byte(TextMessage),
+			wantMsg: "hello",
+		},
+		{
+			name: "fragmented with ping in between",
+			b0s:  []byte{byte(BinaryMessage), finBit | pingFrame, finBit | continuationFrame},
+			frames: [][]byte{
+				[]byte("hel"), []byte("ping"), []byte("lo"),
+			},
+			wantB0:  finBit | byte(BinaryMessage),
+			wantMsg: "hello",
+		},
+		{
+			name:    "extended length",
+			b0s:     []byte{finBit | byte(TextMessage)},
+			frames:  [][]byte{bytes.Repeat([]byte("a"), 300)},
+			wantB0:  finBit | byte(TextMessage),
+			wantMsg: strings.Repeat("a", 300),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for i, frame := range tc.frames {
+				client.writeFrame(t, tc.b0s[i], frame)
+			}
+			b0, payload := client.readFrame(t)
+			if b0 == finBit|pongFrame {
+				assert.Equal(t, "ping", string(payload))
+				b0, payload = client.readFrame(t)
+			}
+			assert.Equal(t, tc.wantB0, b0)
+			assert.Equal(t, tc.wantMsg, string(payload))
+		})
+	}
+
+	client.writeFrame(t, finBit|closeFrame, []byte{0x03, 0xe8})
+	b0, payload := client.readFrame(t)
+	assert.Equal(t, byte(finBit|closeFrame), b0)
+	assert.Equal(t, CloseNormalClosure, int(binary.BigEndian.Uint16(payload)))
+}
+
+func TestConn_ProtocolError(t *testing.T) {
+	server := newEchoServer(t)
+	defer server.Close()
+	client := dial(t, server.URL, "")
+	defer client.conn.Close()
+
+	// 没有起始帧的分片
+	client.writeFrame(t, finBit|continuationFrame, []byte("oops"))
+	b0, payload := client.readFrame(t)
+	assert.Equal(t, byte(finBit|closeFrame), b0)
+	assert.Equal(t, CloseProtocolError, int(binary.BigEndian.Uint16(payload)))
+}
+
+func TestConn_Compression(t *testing.T) {
+	server := newEchoServer(t, WithCompression())
+	defer server.Close()
+	client := dial(t, server.URL, "permessage-deflate; client_max_window_bits")
+	defer client.conn.Close()
+	assert.Contains(t, client.header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+
+	msg := strings.Repeat("compress me ", 20)
+	buf := &bytes.Buffer{}
+	w, err := flate.NewWriter(buf, flate.BestCompression)
+	require.NoError(t, err)
+	_, _ = w.Write([]byte(msg))
+	require.NoError(t, w.Flush())
+	client.writeFrame(t, finBit|rsv1Bit|byte(TextMessage), bytes.TrimSuffix(buf.Bytes(), deflateTail))
+
+	b0, payload := client.readFrame(t)
+	assert.Equal(t, byte(finBit|rsv1Bit|byte(TextMessage)), b0)
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(payload), bytes.NewReader(deflateTail)))
+	data, _ := io.ReadAll(r)
+	assert.Equal(t, msg, string(data))
+
+	// 压缩标记只能出现在第一帧上
+	client.writeFrame(t, rsv1Bit|byte(TextMessage), bytes.TrimSuffix(buf.Bytes(), deflateTail))
+	client.writeFrame(t, finBit|rsv1Bit|continuationFrame, nil)
+	b0, payload = client.readFrame(t)
+	assert.Equal(t, byte(finBit|closeFrame), b0)
+	assert.Equal(t, CloseProtocolError, int(binary.BigEndian.Uint16(payload)))
+}
+
+func TestNegotiateDeflate(t *testing.T) {
+	const base = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+	testCases := []struct {
+		name    string
+		offer   string
+		wantExt string
+		wantOK  bool
+	}{
+		{name: "no offer"},
+		{name: "other extension", offer: "x-webkit-deflate-frame"},
+		{name: "plain", offer: "permessage-deflate", wantExt: base, wantOK: true},
+		{name: "client window", offer: "permessage-deflate; client_max_window_bits", wantExt: base, wantOK: true},
+		{name: "client window value", offer: `permessage-deflate; client_max_window_bits="10"`, wantExt: base, wantOK: true},
+		{name: "no context takeover", offer: "permessage-deflate; server_no_context_takeover", wantExt: base, wantOK: true},
+		{
+			name:    "max server window",
+			offer:   "permessage-deflate; server_max_window_bits=15",
+			wantExt: base + "; server_max_window_bits=15",
+			wantOK:  true,
+		},
+		// 没法用更小的窗口压缩，只能拒绝
+		{name: "small server window", offer: "permessage-deflate; server_max_window_bits=10"},
+		{name: "invalid window", offer: "permessage-deflate; server_max_window_bits=16"},
+		{name: "unknown param", offer: "permessage-deflate; foo"},
+		{name: "duplicated param", offer: "permessage-deflate; server_no_context_takeover; server_no_context_takeover"},
+		{
+			name:    "fallback offer",
+			offer:   "permessage-deflate; server_max_window_bits=10, permessage-deflate",
+			wantExt: base,
+			wantOK:  true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			if tc.offer != "" {
+				header.Set("Sec-WebSocket-Extensions", tc.offer)
+			}
+			ext, ok := negotiateDeflate(header)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.wantExt, ext)
+		})
+	}
+}
+
+func TestUpgrade_HandshakeError(t *testing.T) {
+	testCases := []struct {
+		name       string
+		method     string
+		header     map[string]string
+		wantStatus int
+	}{
+		{
+			name:       "not get",
+			method:     http.MethodPost,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "no upgrade",
+			method:     http.MethodGet,
+			header:     map[string]string{"Connection": "keep-alive"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "bad version",
+			method: http.MethodGet,
+			header: map[string]string{"Connection": "Upgrade", "Upgrade": "websocket",
+				"Sec-WebSocket-Version": "8"},
+			wantStatus: http.StatusUpgradeRequired,
+		},
+		{
+			name:   "cross origin",
+			method: http.MethodGet,
+			header: map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "websocket",
+				"Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ==",
+				"Origin": "https://evil.com"},
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/", nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			_, err := Upgrade(httptest.NewRecorder(), req)
+			he, ok := err.(*HandshakeError)
+			require.True(t, ok)
+			assert.Equal(t, tc.wantStatus, he.Status)
+		})
+	}
+}
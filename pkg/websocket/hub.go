@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBroadcastTimeout 是广播的时候单个连接的默认写超时
+const DefaultBroadcastTimeout = 10 * time.Second
+
+type HubOption func(hub *Hub)
+
+// Hub 按照房间管理连接，用来做广播
+type Hub struct {
+	mu    sync.RWMutex
+	rooms map[string]map[*Conn]struct{}
+
+	writeTimeout time.Duration
+}
+
+func NewHub(opts ...HubOption) *Hub {
+	res := &Hub{
+		rooms:        make(map[string]map[*Conn]struct{}),
+		writeTimeout: DefaultBroadcastTimeout,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// WithBroadcastTimeout 设置广播的时候单个连接的写超时，0 表示不设置超时
+func WithBroadcastTimeout(timeout time.Duration) HubOption {
+	return func(hub *Hub) {
+		hub.writeTimeout = timeout
+	}
+}
+
+func (h *Hub) Join(room string, conn *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	conns, ok := h.rooms[room]
+	if !ok {
+		conns = make(map[*Conn]struct{})
+		h.rooms[room] = conns
+	}
+	conns[conn] = struct{}{}
+}
+
+func (h *Hub) Leave(room string, conn *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leaveLocked(room, conn)
+}
+
+// LeaveAll 把连接从所有房间里移除，一般在连接断开的时候调用
+func (h *Hub) LeaveAll(conn *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for room := range h.rooms {
+		h.leaveLocked(room, conn)
+	}
+}
+
+// Count 返回房间里的连接数
+func (h *Hub) Count(room string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.rooms[room])
+}
+
+// Broadcast 把消息并发地发给房间里所有的连接，一个卡住的客户端不会拖慢其它连接。
+// 发送失败或者超时的连接会被关闭并移出所有房间，返回发送失败的连接数
+func (h *Hub) Broadcast(room string, typ MessageType, data []byte) int {
+	h.mu.RLock()
+	conns := make([]*Conn, 0, len(h.rooms[room]))
+	for conn := range h.rooms[room] {
+		conns = append(conns, conn)
+	}
+	h.mu.RUnlock()
+
+	var deadline time.Time
+	if h.writeTimeout > 0 {
+		deadline = time.Now().Add(h.writeTimeout)
+	}
+	var (
+		wg     sync.WaitGroup
+		failed atomic.Int64
+	)
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *Conn) {
+			defer wg.Done()
+			if err := conn.writeMessage(typ, data, deadline); err != nil {
+				// 写了一半的帧已经没法恢复，只能关闭连接
+				_ = conn.closeNetConn()
+				h.LeaveAll(conn)
+				failed.Add(1)
+			}
+		}(conn)
+	}
+	wg.Wait()
+	return int(failed.Load())
+}
+
+func (h *Hub) BroadcastJSON(room string, val any) (int, error) {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return 0, err
+	}
+	return h.Broadcast(room, TextMessage, data), nil
+}
+
+func (h *Hub) leaveLocked(room string, conn *Conn) {
+	conns, ok := h.rooms[room]
+	if !ok {
+		return
+	}
+	delete(conns, conn)
+	if len(conns) == 0 {
+		delete(h.rooms, room)
+	}
+}
@@ -0,0 +1,48 @@
+package websocket
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHub_Broadcast(t *testing.T) {
+	h := NewHub(WithBroadcastTimeout(100 * time.Millisecond))
+	newPipeConn := func() (*Conn, net.Conn) {
+		server, client := net.Pipe()
+		t.Cleanup(func() {
+			_ = client.Close()
+			_ = server.Close()
+		})
+		return newConn(server, bufio.NewReader(server), "", false, 0), client
+	}
+	active, activeClient := newPipeConn()
+	// 从来不读数据的客户端，写它的时候会一直阻塞
+	stalled, _ := newPipeConn()
+	h.Join("room", active)
+	h.Join("room", stalled)
+
+	received := make(chan []byte, 1)
+	go func() {
+		frame := make([]byte, 7)
+		if _, err := io.ReadFull(activeClient, frame); err == nil {
+			received <- frame
+		}
+	}()
+	start := time.Now()
+	assert.Equal(t, 1, h.Broadcast("room", TextMessage, []byte("hello")))
+	assert.Less(t, time.Since(start), time.Second)
+	select {
+	case frame := <-received:
+		assert.Equal(t, []byte{0x81, 5, 'h', 'e', 'l', 'l', 'o'}, frame)
+	case <-time.After(time.Second):
+		require.FailNow(t, "没有收到广播")
+	}
+	// 超时的连接被关闭并且移出房间
+	assert.Equal(t, 1, h.Count("room"))
+	assert.Equal(t, errConnClosed, stalled.WriteText("again"))
+}
@@ -0,0 +1,242 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// RFC 6455 里规定的固定 GUID，用来计算 Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+type Option func(u *upgrader)
+
+type upgrader struct {
+	checkOrigin    func(r *http.Request) bool
+	subprotocols   []string
+	compress       bool
+	maxMessageSize int64
+}
+
+// HandshakeError 表示握手失败，Status 是应该返回给客户端的状态码
+type HandshakeError struct {
+	Status int
+	Reason string
+}
+
+func (e *HandshakeError) Error() string {
+	return "websocket: " + e.Reason
+}
+
+// WithCheckOrigin 设置校验 Origin 的函数。
+// 默认只允许没有 Origin 或者和 Host 同源的请求
+func WithCheckOrigin(fn func(r *http.Request) bool) Option {
+	return func(u *upgrader) {
+		u.checkOrigin = fn
+	}
+}
+
+// WithSubprotocols 设置服务端支持的子协议，按照优先级排列
+func WithSubprotocols(protocols ...string) Option {
+	return func(u *upgrader) {
+		u.subprotocols = protocols
+	}
+}
+
+// WithCompression 在客户端支持的时候启用 permessage-deflate
+func WithCompression() Option {
+	return func(u *upgrader) {
+		u.compress = true
+	}
+}
+
+// WithMaxMessageSize 限制单条消息（解压之后）的最大字节数
+func WithMaxMessageSize(size int64) Option {
+	return func(u *upgrader) {
+		u.maxMessageSize = size
+	}
+}
+
+// Upgrade 完成 WebSocket 握手并接管底层连接。
+// 接管连接之前失败会返回 *HandshakeError，这时不会往 w 写任何东西，
+// 调用者可以根据 HandshakeError.Status 自己决定如何响应；其它错误说明连接已经被接管并关闭了
+func Upgrade(w http.ResponseWriter, r *http.Request, opts ...Option) (*Conn, error) {
+	u := &upgrader{
+		checkOrigin:    sameOrigin,
+		maxMessageSize: 32 << 20,
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	if r.Method != http.MethodGet {
+		return nil, &HandshakeError{Status: http.StatusMethodNotAllowed, Reason: "握手必须使用 GET 方法"}
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") {
+		return nil, &HandshakeError{Status: http.StatusBadRequest, Reason: "Connection 头部缺少 upgrade"}
+	}
+	if !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, &HandshakeError{Status: http.StatusBadRequest, Reason: "Upgrade 头部缺少 websocket"}
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, &HandshakeError{Status: http.StatusUpgradeRequired, Reason: "只支持版本 13"}
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, &HandshakeError{Status: http.StatusBadRequest, Reason: "非法的 Sec-WebSocket-Key"}
+	}
+	if !u.checkOrigin(r) {
+		return nil, &HandshakeError{Status: http.StatusForbidden, Reason: "Origin 校验失败"}
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, &HandshakeError{Status: http.StatusInternalServerError, Reason: "ResponseWriter 不支持 Hijack"}
+	}
+
+	subprotocol := u.selectSubprotocol(r)
+	var extension string
+	compress := false
+	if u.compress {
+		extension, compress = negotiateDeflate(r.Header)
+	}
+
+	netConn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, &HandshakeError{Status: http.StatusInternalServerError, Reason: err.Error()}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	sb.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\n")
+	sb.WriteString("Sec-WebSocket-Accept: " + computeAccept(key) + "\r\n")
+	if subprotocol != "" {
+		sb.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	if compress {
+		sb.WriteString("Sec-WebSocket-Extensions: " + extension + "\r\n")
+	}
+	sb.WriteString("\r\n")
+	if _, err = netConn.Write([]byte(sb.String())); err != nil {
+		_ = netConn.Close()
+		return nil, err
+	}
+
+	// 客户端可能在握手之后立刻发送数据，这部分数据已经在 brw 的缓冲区里了
+	return newConn(netConn, brw.Reader, subprotocol, compress, u.maxMessageSize), nil
+}
+
+func (u *upgrader) selectSubprotocol(r *http.Request) string {
+	offered := headerTokens(r.Header, "Sec-WebSocket-Protocol")
+	for _, p := range u.subprotocols {
+		for _, o := range offered {
+			if p == o {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+func computeAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// maxWindowBits 是 compress/flate 使用的窗口大小，32KB
+const maxWindowBits = 15
+
+// negotiateDeflate 从客户端的 permessage-deflate 提议里选出第一个能接受的，返回响应的扩展头。
+// 不保留上下文，每条消息单独压缩，省掉每个连接常驻的压缩字典。
+// compress/flate 的窗口固定是 32KB，客户端要求更小的 server_max_window_bits 的时候只能拒绝这个提议
+func negotiateDeflate(header http.Header) (string, bool) {
+	for _, offer := range headerTokens(header, "Sec-WebSocket-Extensions") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		if ext, ok := acceptDeflateOffer(params[1:]); ok {
+			return ext, true
+		}
+	}
+	return "", false
+}
+
+func acceptDeflateOffer(params []string) (string, bool) {
+	ext := "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+	seen := make(map[string]struct{}, len(params))
+	for _, p := range params {
+		name, val, hasVal := strings.Cut(strings.TrimSpace(p), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		val = strings.Trim(strings.TrimSpace(val), `"`)
+		// 同一个参数出现两次的提议是非法的
+		if _, ok := seen[name]; ok {
+			return "", false
+		}
+		seen[name] = struct{}{}
+		switch name {
+		case "server_no_context_takeover", "client_no_context_takeover":
+			if hasVal {
+				return "", false
+			}
+		case "server_max_window_bits":
+			bits, err := strconv.Atoi(val)
+			if err != nil || bits < 8 || bits > 15 {
+				return "", false
+			}
+			if bits < maxWindowBits {
+				return "", false
+			}
+			ext += "; server_max_window_bits=" + strconv.Itoa(maxWindowBits)
+		case "client_max_window_bits":
+			// 客户端表示它可以缩小窗口，不回应就是使用默认的最大窗口，解压没有问题
+			if hasVal {
+				bits, err := strconv.Atoi(val)
+				if err != nil || bits < 8 || bits > 15 {
+					return "", false
+				}
+			}
+		default:
+			return "", false
+		}
+	}
+	return ext, true
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, t := range headerTokens(header, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+func headerTokens(header http.Header, name string) []string {
+	var res []string
+	for _, v := range header.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				res = append(res, t)
+			}
+		}
+	}
+	return res
+}